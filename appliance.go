@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	appneta "github.com/stellaraf/rmon-node-setup/appneta"
+	docker "github.com/stellaraf/rmon-node-setup/docker"
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+// applianceFlags are the flags shared by the appliance lifecycle commands.
+type applianceFlags struct {
+	name          *string
+	nodeID        *string
+	containerName containerNameFlags
+	appNeta       appNetaFlags
+}
+
+func addApplianceFlags(flags *flag.FlagSet) applianceFlags {
+	return applianceFlags{
+		name:          flags.String("name", "", "Name of the AppNeta appliance (default: the container name of this node)"),
+		nodeID:        flags.String("node-id", "", "Node ID (2 digit number) of the node, if it is not this node"),
+		containerName: addContainerNameFlags(flags),
+		appNeta:       addAppNetaFlags(flags),
+	}
+}
+
+/*
+applianceName gets the name of the node's AppNeta appliance, which is the name of its AppNeta
+container. With --node-id, the name is rendered from --container-name & --site as install does. If
+neither --name nor --node-id is given, the container name is read from the installed .env file, and
+if this node hasn't been set up, the node ID is prompted for.
+*/
+func (f applianceFlags) applianceName() string {
+	if *f.name != "" {
+		return *f.name
+	}
+	if *f.nodeID != "" {
+		id, valid := ParseNodeID(*f.nodeID)
+		if !valid {
+			util.Critical("Invalid Node ID %s. Node ID must be a 2 digit number.", *f.nodeID)
+			util.Exit(1)
+		}
+		return f.containerName.render(id)
+	}
+	if name, err := docker.InstalledContainerName(); err == nil {
+		return name
+	}
+	return f.containerName.render(GetNodeID())
+}
+
+// printAppliance prints the details of an appliance.
+func printAppliance(a appneta.Appliance) {
+	fmt.Printf("  %-18s %s\n", "Name:", a.Name)
+	fmt.Printf("  %-18s %d\n", "ID:", a.ID)
+	fmt.Printf("  %-18s %s\n", "Type:", a.Type)
+	fmt.Printf("  %-18s %s\n", "Connection Status:", a.ConnectionStatus)
+	fmt.Printf("  %-18s %s\n", "Last Check-in:", a.LastCheckin)
+	fmt.Printf("  %-18s %s\n", "Software Version:", a.SoftwareVersion)
+	if a.Location != "" {
+		fmt.Printf("  %-18s %s\n", "Location:", a.Location)
+	}
+}
+
+/*
+status shows whether the node's appliance is registered with AppNeta & connected. The exit code is
+1 if it isn't registered or isn't connected.
+*/
+func status(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	opts := addApplianceFlags(flags)
+	logOpts := addLogFlags(flags)
+	flags.Parse(args)
+	logOpts.apply()
+
+	name := opts.applianceName()
+	client := opts.appNeta.client()
+
+	appliance, found, err := client.ApplianceByName(context.Background(), name)
+	util.Check("Error getting AppNeta appliance %s", err, name)
+	if !found {
+		util.Critical("AppNeta appliance %s is not registered", name)
+		util.Exit(1)
+	}
+
+	printAppliance(appliance)
+	if !appliance.Connected() {
+		util.Warning("AppNeta appliance %s is registered, but not connected", name)
+		util.Exit(1)
+	}
+	util.Success("AppNeta appliance %s is registered & connected", name)
+}
+
+/*
+register registers the node's appliance with AppNeta, and waits for it to connect. AppNeta creates a
+container appliance the first time its configuration is downloaded, so if the appliance isn't
+registered, its configuration is downloaded to a private directory & discarded. The appliance only
+connects once the AppNeta container checks in, so the node must be set up for it to connect.
+*/
+func register(args []string) {
+	flags := flag.NewFlagSet("register", flag.ExitOnError)
+	opts := addApplianceFlags(flags)
+	wait := flags.Duration("wait", 10*time.Minute, "Limit of the time to wait for the appliance to connect, or 0 to only register it")
+	interval := flags.Duration("interval", 15*time.Second, "Time between checks of the appliance's status")
+	logOpts := addLogFlags(flags)
+	flags.Parse(args)
+	logOpts.apply()
+
+	name := opts.applianceName()
+	client := opts.appNeta.client()
+
+	_, found, err := client.ApplianceByName(context.Background(), name)
+	util.Check("Error getting AppNeta appliance %s", err, name)
+	if found {
+		util.Info("AppNeta appliance %s is already registered", name)
+	} else {
+		util.Info("Registering AppNeta appliance %s...", name)
+		body, err := client.DownloadConfiguration(context.Background(), appneta.ApplianceTypeDockerCompose, name, util.PrivateTempDir("rmon-appneta-"))
+		util.Check("Error registering AppNeta appliance %s", err, name)
+		body.Close()
+		util.Success("Registered AppNeta appliance %s", name)
+	}
+	if *wait <= 0 {
+		return
+	}
+
+	util.Info("Waiting up to %s for AppNeta appliance %s to connect...", wait.String(), name)
+	deadline := time.Now().Add(*wait)
+	for {
+		appliance, found, err := client.ApplianceByName(context.Background(), name)
+		util.Check("Error getting AppNeta appliance %s", err, name)
+		if found && appliance.Connected() {
+			printAppliance(appliance)
+			util.Success("AppNeta appliance %s is registered & connected", name)
+			return
+		}
+		if time.Now().Add(*interval).After(deadline) {
+			if found {
+				printAppliance(appliance)
+				util.Critical("AppNeta appliance %s did not connect within %s. Check that the appneta-cmp service is running.", name, wait.String())
+			} else {
+				util.Critical("AppNeta appliance %s was registered, but isn't listed in organization %s", name, *opts.appNeta.org)
+			}
+			util.Exit(1)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+/*
+deregister deletes the node's appliance from AppNeta, e.g. when the node is decommissioned. If the
+AppNeta container is still running, the appliance will register itself again, so it should be
+stopped first.
+*/
+func deregister(args []string) {
+	flags := flag.NewFlagSet("deregister", flag.ExitOnError)
+	opts := addApplianceFlags(flags)
+	yes := flags.Bool("yes", false, "Don't ask for confirmation")
+	logOpts := addLogFlags(flags)
+	flags.Parse(args)
+	logOpts.apply()
+
+	name := opts.applianceName()
+	client := opts.appNeta.client()
+
+	appliance, found, err := client.ApplianceByName(context.Background(), name)
+	util.Check("Error getting AppNeta appliance %s", err, name)
+	if !found {
+		util.Warning("AppNeta appliance %s is not registered, nothing to do", name)
+		return
+	}
+	printAppliance(appliance)
+
+	if !*yes {
+		var answer string
+		fmt.Printf("Delete AppNeta appliance %s? [y/N]: ", name)
+		fmt.Scanln(&answer)
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			util.Warning("Not deleting AppNeta appliance %s", name)
+			util.Exit(1)
+		}
+	}
+
+	err = client.DeleteAppliance(context.Background(), appliance.ID)
+	util.Check("Error deleting AppNeta appliance %s", err, name)
+	util.Success("Deleted AppNeta appliance %s", name)
+	util.Info("If the AppNeta container is still running, stop it with 'systemctl disable --now appneta-cmp', or the appliance will register again.")
+}
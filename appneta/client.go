@@ -0,0 +1,233 @@
+package appneta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	retry "github.com/stellaraf/rmon-node-setup/internal/retry"
+)
+
+// DefaultBaseURL is the base URL of Stellar's AppNeta portal.
+const DefaultBaseURL string = "https://app-14.pm.appneta.com"
+
+// DefaultOrgID is the ID of Stellar's AppNeta organization.
+const DefaultOrgID string = "17992"
+
+// DefaultUserAgent is the User-Agent header sent with each request.
+const DefaultUserAgent string = "rmon-node-setup"
+
+// DefaultTimeout is the default timeout of each request.
+const DefaultTimeout time.Duration = 5 * time.Minute
+
+// DefaultRetries is the default number of times a failed request is retried.
+const DefaultRetries int = 3
+
+// ApplianceTypeDockerCompose is the appliance configuration type of a Docker Compose container
+// appliance.
+const ApplianceTypeDockerCompose string = "DOCKER_COMPOSE"
+
+// DefaultMinBackoff is the default wait before the first retry.
+const DefaultMinBackoff time.Duration = 2 * time.Second
+
+// DefaultMaxBackoff is the default maximum wait between retries.
+const DefaultMaxBackoff time.Duration = time.Minute
+
+// DefaultDeadline is the default limit of the total time spent on a request, including retries.
+const DefaultDeadline time.Duration = 15 * time.Minute
+
+// Client is an AppNeta v3 API client.
+type Client struct {
+	// BaseURL is the base URL of the AppNeta portal, e.g. https://app-14.pm.appneta.com.
+	BaseURL string
+	// OrgID is the ID of the AppNeta organization.
+	OrgID string
+	// Token is the AppNeta API token.
+	Token string
+	// Timeout is the timeout of each attempt of a request, including reading the response body.
+	Timeout time.Duration
+	// Retries is the number of times a request is retried after a transient error, i.e. a
+	// timeout, reset or refused connection, truncated response, or 5xx, 408 or 429 response.
+	Retries int
+	// MinBackoff & MaxBackoff bound the exponential backoff between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Deadline limits the total time spent on a request, including retries. Zero means no limit.
+	Deadline time.Duration
+	// Progress, if set, is called as a download progresses with the number of bytes received & the
+	// total size, which is -1 if unknown.
+	Progress func(received, total int64)
+	// OnRetry, if set, is called before each retry with the attempt number, the time until the
+	// retry & the error which caused it.
+	OnRetry func(attempt int, wait time.Duration, err error)
+	// UserAgent is the User-Agent header sent with each request.
+	UserAgent string
+	// HTTP is the underlying HTTP client.
+	HTTP *http.Client
+}
+
+// AppNetaError is the JSON response received from AppNeta if there is an error with the request.
+type AppNetaError struct {
+	HTTPStatusCode int      `json:"httpStatusCode"`
+	Messages       []string `json:"messages"`
+}
+
+func (e *AppNetaError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("AppNeta API returned %d", e.HTTPStatusCode)
+	}
+	return fmt.Sprintf("AppNeta API returned %d: %s", e.HTTPStatusCode, strings.Join(e.Messages, "; "))
+}
+
+// Appliance is an AppNeta appliance (monitoring point).
+type Appliance struct {
+	ID               int    `json:"id"`
+	Name             string `json:"name"`
+	OrgID            int    `json:"orgId"`
+	Model            string `json:"model"`
+	Type             string `json:"type"`
+	SerialNumber     string `json:"serialNumber"`
+	SoftwareVersion  string `json:"softwareVersion"`
+	ConnectionStatus string `json:"connectionStatus"`
+	LastCheckin      string `json:"lastCheckin"`
+	Location         string `json:"location"`
+}
+
+// Connected determines if the appliance is currently connected to AppNeta.
+func (a Appliance) Connected() bool {
+	return strings.EqualFold(a.ConnectionStatus, "connected")
+}
+
+// NewClient creates an AppNeta API client with the default timeout, retries & user agent.
+func NewClient(baseURL, orgID, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		OrgID:      orgID,
+		Token:      token,
+		Timeout:    DefaultTimeout,
+		Retries:    DefaultRetries,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		Deadline:   DefaultDeadline,
+		UserAgent:  DefaultUserAgent,
+		HTTP:       &http.Client{},
+	}
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	u := c.BaseURL + "/api/v3" + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// decodeError reads an AppNetaError from an error response.
+func decodeError(res *http.Response) error {
+	appNetaErr := &AppNetaError{}
+	b, _ := ioutil.ReadAll(res.Body)
+	if json.Unmarshal(b, appNetaErr) != nil || len(appNetaErr.Messages) == 0 {
+		if m := strings.TrimSpace(string(b)); m != "" {
+			appNetaErr.Messages = []string{m}
+		}
+	}
+	if appNetaErr.HTTPStatusCode == 0 {
+		appNetaErr.HTTPStatusCode = res.StatusCode
+	}
+	return appNetaErr
+}
+
+/*
+send sends a single request to the AppNeta API. If AppNeta responds with an error status, it is
+returned as an AppNetaError. Otherwise, the caller is responsible for closing the response body.
+*/
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url(path, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+c.Token)
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode > 399 {
+		defer res.Body.Close()
+		return nil, decodeError(res)
+	}
+	return res, nil
+}
+
+/*
+request sends a request to the AppNeta API with retries. If out is not nil, the JSON response is
+decoded into it.
+*/
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, out interface{}) error {
+	ctx, cancel := retry.WithTimeout(ctx, c.Deadline)
+	defer cancel()
+	return c.retry(ctx, func() error {
+		actx, cancel := retry.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+		res, err := c.send(actx, method, path, query, http.Header{"Accept": {"application/json"}})
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if out == nil {
+			_, err = io.Copy(ioutil.Discard, res.Body)
+			return err
+		}
+		return json.NewDecoder(res.Body).Decode(out)
+	})
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	return c.request(ctx, http.MethodGet, path, query, out)
+}
+
+// Appliances lists the appliances in the organization.
+func (c *Client) Appliances(ctx context.Context) (appliances []Appliance, err error) {
+	err = c.getJSON(ctx, "/appliance", url.Values{"orgId": {c.OrgID}}, &appliances)
+	return
+}
+
+// Appliance gets an appliance by ID.
+func (c *Client) Appliance(ctx context.Context, id int) (appliance Appliance, err error) {
+	err = c.getJSON(ctx, fmt.Sprintf("/appliance/%d", id), nil, &appliance)
+	return
+}
+
+// ApplianceByName finds an appliance in the organization by name. If none exists, found is false.
+func (c *Client) ApplianceByName(ctx context.Context, name string) (appliance Appliance, found bool, err error) {
+	appliances, err := c.Appliances(ctx)
+	if err != nil {
+		return
+	}
+	for _, a := range appliances {
+		if a.Name == name {
+			return a, true, nil
+		}
+	}
+	return
+}
+
+/*
+DeleteAppliance deletes an appliance from the organization. A container appliance which is still
+running will register itself again the next time it checks in.
+*/
+func (c *Client) DeleteAppliance(ctx context.Context, id int) error {
+	return c.request(ctx, http.MethodDelete, fmt.Sprintf("/appliance/%d", id), nil, nil)
+}
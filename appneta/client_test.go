@@ -0,0 +1,175 @@
+package appneta
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// apiServer serves handler, checking that each request is authenticated.
+func apiServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Token token" {
+			t.Errorf("Authorization is %q", got)
+		}
+		if got := r.Header.Get("User-Agent"); got != DefaultUserAgent {
+			t.Errorf("User-Agent is %q", got)
+		}
+		handler(w, r)
+	}))
+}
+
+const testAppliances = `[
+	{"id": 1, "name": "rpi01-rmon-orion-cloud", "orgId": 1, "type": "DOCKER_COMPOSE", "connectionStatus": "Connected"},
+	{"id": 2, "name": "rpi02-rmon-orion-cloud", "orgId": 1, "type": "DOCKER_COMPOSE", "connectionStatus": "Disconnected"}
+]`
+
+// appliancesHandler lists testAppliances for org 1.
+func appliancesHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/v3/appliance" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.URL.Query().Get("orgId"); got != "1" {
+			t.Errorf("orgId is %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(testAppliances))
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	c := NewClient("https://appneta.example.com/", "1", "token")
+	if got := c.url("/appliance", nil); got != "https://appneta.example.com/api/v3/appliance" {
+		t.Errorf("got URL %s", got)
+	}
+}
+
+func TestAppliances(t *testing.T) {
+	server := apiServer(t, appliancesHandler(t))
+	defer server.Close()
+
+	appliances, err := testClient(server).Appliances(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Appliance{
+		{ID: 1, Name: "rpi01-rmon-orion-cloud", OrgID: 1, Type: "DOCKER_COMPOSE", ConnectionStatus: "Connected"},
+		{ID: 2, Name: "rpi02-rmon-orion-cloud", OrgID: 1, Type: "DOCKER_COMPOSE", ConnectionStatus: "Disconnected"},
+	}
+	if !reflect.DeepEqual(appliances, want) {
+		t.Errorf("got %+v, want %+v", appliances, want)
+	}
+	if !appliances[0].Connected() || appliances[1].Connected() {
+		t.Error("Connected doesn't match the connection status")
+	}
+}
+
+func TestApplianceByName(t *testing.T) {
+	server := apiServer(t, appliancesHandler(t))
+	defer server.Close()
+	c := testClient(server)
+
+	tests := []struct {
+		name  string
+		id    int
+		found bool
+	}{
+		{"rpi02-rmon-orion-cloud", 2, true},
+		{"rpi03-rmon-orion-cloud", 0, false},
+	}
+	for _, tt := range tests {
+		a, found, err := c.ApplianceByName(context.Background(), tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != tt.found || a.ID != tt.id {
+			t.Errorf("%s: got appliance %d, found %v, want %d, %v", tt.name, a.ID, found, tt.id, tt.found)
+		}
+	}
+}
+
+func TestDeleteAppliance(t *testing.T) {
+	deleted := false
+	server := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/api/v3/appliance/2" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer server.Close()
+
+	if err := testClient(server).DeleteAppliance(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if !deleted {
+		t.Error("the appliance wasn't deleted")
+	}
+}
+
+func TestAppNetaError(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        string
+		want        AppNetaError
+		message     string
+	}{
+		{
+			"JSON", "application/json", http.StatusNotFound,
+			`{"httpStatusCode": 404, "messages": ["Appliance 2 not found"]}`,
+			AppNetaError{HTTPStatusCode: 404, Messages: []string{"Appliance 2 not found"}},
+			"AppNeta API returned 404: Appliance 2 not found",
+		},
+		{
+			"JSON without a status", "application/json", http.StatusForbidden,
+			`{"messages": ["Forbidden", "Invalid token"]}`,
+			AppNetaError{HTTPStatusCode: 403, Messages: []string{"Forbidden", "Invalid token"}},
+			"AppNeta API returned 403: Forbidden; Invalid token",
+		},
+		{
+			"plain text", "text/plain", http.StatusUnauthorized,
+			"Unauthorized\n",
+			AppNetaError{HTTPStatusCode: 401, Messages: []string{"Unauthorized"}},
+			"AppNeta API returned 401: Unauthorized",
+		},
+		{
+			"empty", "text/plain", http.StatusBadRequest,
+			"",
+			AppNetaError{HTTPStatusCode: 400},
+			"AppNeta API returned 400",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			defer server.Close()
+
+			err := testClient(server).DeleteAppliance(context.Background(), 2)
+			var appNetaErr *AppNetaError
+			if !errors.As(err, &appNetaErr) {
+				t.Fatalf("got %v, want an AppNetaError", err)
+			}
+			if !reflect.DeepEqual(*appNetaErr, tt.want) {
+				t.Errorf("got %+v, want %+v", *appNetaErr, tt.want)
+			}
+			if err.Error() != tt.message {
+				t.Errorf("got message %q, want %q", err.Error(), tt.message)
+			}
+			if attempts != 1 {
+				t.Errorf("a %d response was retried", tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package appneta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	retry "github.com/stellaraf/rmon-node-setup/internal/retry"
+)
+
+// tempFile is a downloaded file which is removed once it is closed.
+type tempFile struct {
+	*os.File
+}
+
+func (t tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.File.Name())
+	return err
+}
+
+// progressWriter reports the number of bytes written through it.
+type progressWriter struct {
+	w        io.Writer
+	received int64
+	total    int64
+	report   func(received, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.received += int64(n)
+	p.report(p.received, p.total)
+	return n, err
+}
+
+// truncate empties f, so that a download starts over.
+func truncate(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// requestDownload requests path from offset onwards.
+func (c *Client) requestDownload(ctx context.Context, path string, offset int64) (*http.Response, error) {
+	header := http.Header{"Accept": {"application/gzip"}}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return c.send(ctx, http.MethodPost, path, nil, header)
+}
+
+/*
+downloadAttempt downloads path into f. If f already contains part of the response from a previous
+attempt, only the remainder is requested. If AppNeta ignores the range & sends the whole response,
+or can't satisfy the range, e.g. because the configuration changed between attempts, f is truncated
+& the download starts over.
+*/
+func (c *Client) downloadAttempt(ctx context.Context, path string, f *os.File) error {
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := retry.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	res, err := c.requestDownload(ctx, path, offset)
+	var appNetaErr *AppNetaError
+	if offset > 0 && errors.As(err, &appNetaErr) && appNetaErr.HTTPStatusCode == http.StatusRequestedRangeNotSatisfiable {
+		if err = truncate(f); err != nil {
+			return err
+		}
+		offset = 0
+		res, err = c.requestDownload(ctx, path, offset)
+	}
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	total := res.ContentLength
+	if offset > 0 && res.StatusCode == http.StatusPartialContent {
+		if total >= 0 {
+			total += offset
+		}
+	} else if offset > 0 {
+		if err = truncate(f); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	var w io.Writer = f
+	if c.Progress != nil {
+		w = &progressWriter{w: f, received: offset, total: total, report: c.Progress}
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+/*
+DownloadConfiguration downloads the configuration bundle of a container appliance, e.g. the
+gzipped tarball of the docker-compose file, .env file & setup script of a DOCKER_COMPOSE appliance.
+If no appliance with name exists, AppNeta creates it.
+
+Transient errors are retried with backoff. If a download is interrupted, the next attempt resumes
+where it left off. The bundle is downloaded to a private temporary file in dir, which is removed when
+the returned reader is closed. The bundle contains secrets, so dir should be a private directory
+which is removed on every exit, e.g. one created by util.PrivateTempDir, in case the process is
+interrupted before the reader is closed.
+*/
+func (c *Client) DownloadConfiguration(ctx context.Context, applianceType, name, dir string) (io.ReadCloser, error) {
+	path := fmt.Sprintf("/appliance/configuration/%s/%s/%s", url.PathEscape(c.OrgID), url.PathEscape(applianceType), url.PathEscape(name))
+
+	f, err := ioutil.TempFile(dir, "appneta-configuration-")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := retry.WithTimeout(ctx, c.Deadline)
+	defer cancel()
+	err = c.retry(ctx, func() error {
+		return c.downloadAttempt(ctx, path, f)
+	})
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tempFile{f}.Close()
+		return nil, err
+	}
+	return tempFile{f}, nil
+}
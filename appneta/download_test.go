@@ -0,0 +1,163 @@
+package appneta
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testBundle = "0123456789abcdefghij"
+
+// testClient creates a client of server which retries without waiting.
+func testClient(server *httptest.Server) *Client {
+	c := NewClient(server.URL, "1", "token")
+	c.MinBackoff, c.MaxBackoff = time.Millisecond, time.Millisecond
+	return c
+}
+
+// truncated sends the headers of the whole bundle, but only n bytes of it before closing the connection.
+func truncated(t *testing.T, w http.ResponseWriter, n int) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(testBundle)))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(testBundle[:n]))
+	w.(http.Flusher).Flush()
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+/*
+download downloads a bundle from handler into a temporary directory, and checks that the bundle is
+only kept there until it is closed, or the download fails.
+*/
+func download(t *testing.T, handler http.HandlerFunc) (string, error) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "appneta-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		if files, _ := ioutil.ReadDir(dir); len(files) > 0 {
+			t.Errorf("%s was left in the download directory", files[0].Name())
+		}
+	}()
+
+	r, err := testClient(server).DownloadConfiguration(context.Background(), ApplianceTypeDockerCompose, "rpi01-rmon-orion-cloud", dir)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("the bundle wasn't downloaded to %s", dir)
+	}
+	b, err := ioutil.ReadAll(r)
+	return string(b), err
+}
+
+func TestDownloadResume(t *testing.T) {
+	var ranges []string
+	got, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/appliance/configuration/1/DOCKER_COMPOSE/rpi01-rmon-orion-cloud" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			truncated(t, w, 5)
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 5-19/20")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(testBundle[5:]))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != testBundle {
+		t.Errorf("downloaded %q, want %q", got, testBundle)
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=5-" {
+		t.Errorf("unexpected ranges %q", ranges)
+	}
+}
+
+func TestDownloadRangeIgnored(t *testing.T) {
+	requests := 0
+	got, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			truncated(t, w, 5)
+			return
+		}
+		w.Write([]byte(testBundle))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != testBundle {
+		t.Errorf("downloaded %q, want %q", got, testBundle)
+	}
+}
+
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	var ranges []string
+	got, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		switch {
+		case len(ranges) == 1:
+			truncated(t, w, 5)
+		case r.Header.Get("Range") != "":
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		default:
+			w.Write([]byte(testBundle))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != testBundle {
+		t.Errorf("downloaded %q, want %q", got, testBundle)
+	}
+	if len(ranges) != 3 || ranges[1] != "bytes=5-" || ranges[2] != "" {
+		t.Errorf("unexpected ranges %q", ranges)
+	}
+}
+
+func TestDownloadNotRetried(t *testing.T) {
+	requests := 0
+	_, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"httpStatusCode":403,"messages":["Invalid API token"]}`))
+	})
+	appNetaErr, ok := err.(*AppNetaError)
+	if !ok || appNetaErr.HTTPStatusCode != http.StatusForbidden || appNetaErr.Messages[0] != "Invalid API token" {
+		t.Fatalf("expected a 403 AppNetaError, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("a 403 was requested %d times", requests)
+	}
+}
+
+func TestDownloadRetriesExhausted(t *testing.T) {
+	requests := 0
+	_, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if requests != DefaultRetries+1 {
+		t.Errorf("requested %d times, want %d", requests, DefaultRetries+1)
+	}
+}
@@ -0,0 +1,34 @@
+package appneta
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	retry "github.com/stellaraf/rmon-node-setup/internal/retry"
+)
+
+/*
+Retriable determines if a failed request may succeed if it is retried. Transient network errors
+(see retry.Transient) & 5xx responses are retriable, as are 408 & 429 responses, which ask the client
+to try again later. Any other AppNeta error is not.
+*/
+func Retriable(err error) bool {
+	var appNetaErr *AppNetaError
+	if errors.As(err, &appNetaErr) {
+		code := appNetaErr.HTTPStatusCode
+		return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	return retry.Transient(err)
+}
+
+// retry calls f with the client's retry policy.
+func (c *Client) retry(ctx context.Context, f func() error) error {
+	return retry.Do(ctx, retry.Policy{
+		Retries:    c.Retries,
+		MinBackoff: c.MinBackoff,
+		MaxBackoff: c.MaxBackoff,
+		Retriable:  Retriable,
+		OnRetry:    c.OnRetry,
+	}, f)
+}
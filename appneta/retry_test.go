@@ -0,0 +1,36 @@
+package appneta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// TestRetriable tests the status codes which are retried. Transient network errors are tested with
+// retry.Transient.
+func TestRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", fmt.Errorf("post: %w", context.Canceled), false},
+		{"transient", io.ErrUnexpectedEOF, true},
+		{"other error", errors.New("invalid URL"), false},
+		{"500", &AppNetaError{HTTPStatusCode: 500}, true},
+		{"503", fmt.Errorf("wrapped: %w", &AppNetaError{HTTPStatusCode: 503}), true},
+		{"408", &AppNetaError{HTTPStatusCode: 408}, true},
+		{"429", &AppNetaError{HTTPStatusCode: 429}, true},
+		{"400", &AppNetaError{HTTPStatusCode: 400}, false},
+		{"401", &AppNetaError{HTTPStatusCode: 401}, false},
+		{"404", &AppNetaError{HTTPStatusCode: 404}, false},
+	}
+	for _, tt := range tests {
+		if got := Retriable(tt.err); got != tt.want {
+			t.Errorf("%s: Retriable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+
+	docker "github.com/stellaraf/rmon-node-setup/docker"
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+/*
+bundle creates an offline bundle of a node's AppNeta configuration & images, on a workstation with
+access to the AppNeta portal & registry, for use with `install --appneta-bundle`.
+*/
+func bundle(args []string) {
+	flags := flag.NewFlagSet("bundle", flag.ExitOnError)
+	nodeID := flags.String("node-id", "", "Node ID (2 digit number) of the node the bundle is for")
+	output := flags.String("output", "", "Path of the bundle to write (default <container name>.tar.gz)")
+	nameOpts := addContainerNameFlags(flags)
+	appNetaOpts := addAppNetaFlags(flags)
+	logOpts := addLogFlags(flags)
+	flags.Parse(args)
+	logOpts.apply()
+
+	id, valid := ParseNodeID(*nodeID)
+	if *nodeID == "" {
+		id = GetNodeID()
+	} else if !valid {
+		util.Critical("Invalid Node ID %s. Node ID must be a 2 digit number.", *nodeID)
+		util.Exit(1)
+	}
+	// The bundle must be created with the same --container-name & --site as the install which uses it.
+	name := nameOpts.render(id)
+
+	if *output == "" {
+		*output = name + ".tar.gz"
+	}
+
+	docker.Bundle(appNetaOpts.client(), name, *output)
+}
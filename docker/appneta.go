@@ -1,38 +1,26 @@
 package docker
 
 import (
-	"archive/tar"
-	"bytes"
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"os/exec"
-	"os/user"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 
-	g "github.com/stellaraf/rmon-node-setup/globals"
+	appneta "github.com/stellaraf/rmon-node-setup/appneta"
+	dotenv "github.com/stellaraf/rmon-node-setup/dotenv"
 	"github.com/stellaraf/rmon-node-setup/systemd"
 	util "github.com/stellaraf/rmon-node-setup/util"
-	gjson "github.com/tidwall/gjson"
 )
 
-const orgID = "17992"
-const urlTmpl = "https://app-14.pm.appneta.com/api/v3/appliance/configuration/%s/DOCKER_COMPOSE/%s"
-
-// AppNetaError is the JSON response received from AppNeta if there is an error with the request.
-type AppNetaError struct {
-	HTTPStatusCode int      `json:"httpStatusCode"`
-	Messages       []string `json:"messages"`
-}
-
 /*
 appNetaEnv is a Golang representation of the AppNeta .env file, e.g.:
+
 	APPNETA_SERVER_ADDRESS=app-14.pm.appneta.com
 	APPNETA_SERVER_KEY=9U5AG-Y71V-W-P
 	APPNETA_SERVER_PORTS=80,8080
@@ -45,139 +33,92 @@ type appNetaEnv struct {
 	ServerPorts   []string
 	ContainerUUID string
 	ContainerName string
+	// Vars contains every variable in the .env file, including those not listed above.
+	Vars map[string]string
 }
 
-// GetCompose downloads the docker compose image from the AppNeta portal.
-func GetCompose(apiKey string, hostname string, outDir string) {
+/*
+GetCompose downloads the docker compose image from the AppNeta portal into workDir, and extracts it
+there. workDir should be a private directory such as one created by util.PrivateTempDir, so that the
+bundle's secrets are removed on every exit. The path of the extracted bundle is returned.
+*/
+func GetCompose(client *appneta.Client, hostname string, workDir string) (dir string) {
 	util.Info("Downloading AppNeta Docker image...")
 
-	url := fmt.Sprintf(urlTmpl, orgID, hostname)
-
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, nil)
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("accept", "application/gzip")
-	req.Header.Add("Authorization", fmt.Sprintf("Token %s", apiKey))
-
-	res, err := client.Do(req)
-	util.Check("Error getting AppNeta Docker image: ", err)
-	defer res.Body.Close()
-
-	if res.StatusCode > 399 {
-		appNetaErr := AppNetaError{}
-
-		err := json.NewDecoder(res.Body).Decode(&appNetaErr)
-		util.Check("Unable to read error response: ", err)
-
+	body, err := client.DownloadConfiguration(context.Background(), appneta.ApplianceTypeDockerCompose, hostname, workDir)
+	var appNetaErr *appneta.AppNetaError
+	if errors.As(err, &appNetaErr) && !appneta.Retriable(appNetaErr) {
 		for _, m := range appNetaErr.Messages {
 			util.Critical(m)
 		}
-		os.Exit(1)
+		util.Exit(1)
 	}
+	util.Check("Error getting AppNeta Docker image: ", err)
 
-	outTarget := filepath.Join(outDir, hostname)
-
-	if _, err := os.Stat(outTarget); !os.IsNotExist(err) {
-		err := os.RemoveAll(outTarget)
-		util.Check(fmt.Sprintf("Directory %s already exists, and it was not able to be deleted: ", outTarget), err)
-		util.Info("Directory %s already exists, and it was deleted.", outTarget)
-	}
-
-	tarReader := tar.NewReader(res.Body)
-
-	for {
-		header, err := tarReader.Next()
-
-		if err == io.EOF {
-			break
-		}
-		util.Check("Header error: ", err)
-
-		path := filepath.Join(outDir, header.Name)
-		info := header.FileInfo()
-
-		if info.IsDir() {
-			if err = os.MkdirAll(path, info.Mode()); err != nil {
-				util.Check("Error unpacking AppNeta Docker image: ", err)
-			}
-			continue
-		}
-		file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-		util.Check(fmt.Sprintf("Error creating path %s: ", path), err)
-		defer file.Close()
-
-		_, err = io.Copy(file, tarReader)
-		util.Check("Error writing unpacked file: ", err)
+	dir = extractBundle(body, hostname, workDir, util.ExtractOptions{})
+	util.Success("Downloaded AppNeta Docker image to %s", dir)
+	return
+}
 
-		user, err := user.Lookup(g.LocalUser)
-		uid, _ := strconv.Atoi(user.Uid)
-		gid, _ := strconv.Atoi(user.Gid)
-		err = os.Chown(path, uid, gid)
-		util.Check("Error changing ownership of %s to user %s", err, path, user.Name)
+/*
+extractBundle extracts an AppNeta configuration bundle into workDir & closes r. The bundle contains
+the registry password & server key, so files & directories are only accessible by root. The path
+of the bundle's directory, which is named after the appliance, is returned.
+*/
+func extractBundle(r io.ReadCloser, hostname string, workDir string, opts util.ExtractOptions) (dir string) {
+	opts.FileMode, opts.DirMode = 0600, 0700
+	opts.UID, opts.GID = -1, -1
+
+	files, err := util.Extract(r, workDir, opts)
+	r.Close()
+	util.Check("Error unpacking AppNeta Docker image: ", err)
+	util.Info("Extracted %s files to %s", strconv.Itoa(len(files)), workDir)
+
+	dir = filepath.Join(workDir, hostname)
+	if !util.FileExists(dir) {
+		util.Critical("AppNeta configuration bundle does not contain a %s directory", hostname)
+		util.Exit(1)
 	}
+	return
+}
 
-	if _, err := os.Stat(outTarget); os.IsNotExist(err) {
-		util.Critical("Unable to download AppNeta Docker Image")
-		os.Exit(1)
+// parseEnv gets the known AppNeta values from a parsed .env file.
+func parseEnv(f *dotenv.File) (env appNetaEnv) {
+	env.Vars = f.Map()
+	env.ServerAddress = env.Vars["APPNETA_SERVER_ADDRESS"]
+	env.ServerKey = env.Vars["APPNETA_SERVER_KEY"]
+	if ports := env.Vars["APPNETA_SERVER_PORTS"]; ports != "" {
+		env.ServerPorts = strings.Split(ports, ",")
 	}
-
-	util.Success("Downloaded AppNeta Docker image to %s", outTarget)
+	env.ContainerUUID = env.Vars["APPNETA_CONTAINER_UUID"]
+	env.ContainerName = env.Vars["APPNETA_CONTAINER_NAME"]
+	return
 }
 
 func readEnv(filename string) (env appNetaEnv) {
 	if !util.FileExists(filename) {
 		util.Check("AppNeta .env file does not exist at %s", os.ErrNotExist, filename)
 	}
-	b, err := ioutil.ReadFile(filename)
+	f, err := dotenv.Load(filename)
 	util.Check("Error reading AppNeta .env file %s", err, filename)
-	lines := strings.Split(string(b), "\n")
-	for _, l := range lines {
-		if l != "\n" {
-			v := strings.Split(l, "=")
-			switch v[0] {
-			case "APPNETA_SERVER_ADDRESS":
-				env.ServerAddress = v[1]
-			case "APPNETA_SERVER_KEY":
-				env.ServerKey = v[1]
-			case "APPNETA_SERVER_PORTS":
-				ports := strings.Split(v[1], ",")
-				env.ServerPorts = ports
-			case "APPNETA_CONTAINER_UUID":
-				env.ContainerUUID = v[1]
-			case "APPNETA_CONTAINER_NAME":
-				env.ContainerName = v[1]
-			}
-		}
-	}
-	return
+	return parseEnv(f)
 }
 
-func modifyCompose(filename string) {
-	if !util.FileExists(filename) {
-		util.Check("AppNeta docker-compose file does not exist at %s", os.ErrNotExist, filename)
-	}
-	in, err := ioutil.ReadFile(filename)
-	util.Check("Error reading docker-compose file %s", err, filename)
-	lines := strings.Split(string(in), "\n")
-	cnOld := "--containername localhost"
-	cnNew := "--containername talos-001"
-	networkMode := "network_mode: host"
-	for i, l := range lines {
-		if strings.Contains(l, networkMode) {
-			// See line 92 of the AppNeta bash script. This is the equivalent to:
-			// `sed -i '/network_mode:\ host/d' mp-compose.yaml`
-			lines[i] = ""
-			util.Info("Deleted %s line from %s", networkMode, filename)
-		} else if strings.Contains(l, cnOld) {
-			// See line 93 of the AppNeta bash script. This is the equivalent to:
-			// `sed -i 's/containername\ localhost/containername\ talos-001/g' mp-compose.yaml`
-			strings.ReplaceAll(lines[i], cnOld, cnNew)
-			util.Info("Replaced %s with %s in %s", cnOld, cnNew, filename)
-		}
+// setEnvValue sets the value of key in a .env file, adding it if it does not exist. Comments &
+// other keys are left as-is.
+func setEnvValue(filename, key, value string) {
+	info, err := os.Stat(filename)
+	util.Check("Error reading .env file %s", err, filename)
+	f, err := dotenv.Load(filename)
+	util.Check("Error reading .env file %s", err, filename)
+	err = f.Set(key, value)
+	util.Check("Error setting %s in .env file %s", err, key, filename)
+	uid, gid := 0, 0
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		uid, gid = int(st.Uid), int(st.Gid)
 	}
-	out := strings.Join(lines, "\n")
-	err = ioutil.WriteFile(filename, []byte(out), 0644)
-	util.Check("Error writing to docker-compose file %s", err, filename)
+	err = util.WriteFile(filename, f.Bytes(), util.FileOptions{Perm: info.Mode().Perm(), UID: uid, GID: gid})
+	util.Check("Error writing .env file %s", err, filename)
 }
 
 func getPassword(filename string) (pw string) {
@@ -216,43 +157,14 @@ func getRegistry(filename string) (r string) {
 	return
 }
 
-/*
-dockerLoginStatus reads the ~/.docker/config.json file, which stores active Docker Registry login
-states. If we're authenticated to the AppNeta registry, return true. If not, return false.
-*/
-func dockerLoginStatus(r string) (s bool) {
-	user, _ := user.Current()
-	filename := filepath.Join(user.HomeDir, ".docker", "config.json")
-	ex := util.FileExists(filename)
-	if !ex {
-		s = false
-		return
-	}
-	file, err := os.Open(filename)
-	util.Check("Error opening docker config file %s", err, filename)
-	defer file.Close()
-
-	b, err := ioutil.ReadAll(file)
-	util.Check("Error reading docker config file %s", err, filename)
-
-	/* The registry name is a URL with a path, e.g. host.example.com/path. However, Docker stores
-	the registry name as an FQDN, e.g. host.example.com.
-	*/
-	reg := strings.Split(r, "/")[0]
-
-	/* GJSON supports some sweet path finding, but requires that keys containing real dots,
-	be escaped. For example in {"person": {"first.name": "Bob"}}, we would need to get "Bob"
-	with: `person.first\.name`.
-	*/
-	gk := "auths." + strings.ReplaceAll(reg, ".", `\.`)
-
-	j := gjson.ParseBytes(b)
-	ke := j.Get(gk)
-	if ke.Exists() {
-		s = true
+// registryAuth reads the AppNeta registry credentials from an extracted configuration bundle.
+func registryAuth(dir string) AuthConfig {
+	env := readEnv(filepath.Join(dir, ".env"))
+	return AuthConfig{
+		Username:      "TOK-" + env.ServerKey,
+		Password:      getPassword(filepath.Join(dir, "tok.txt")),
+		ServerAddress: getRegistry(filepath.Join(dir, "setup.sh")),
 	}
-
-	return
 }
 
 /*
@@ -260,17 +172,18 @@ SetupCompose runs AppNeta's docker-compose setup script.
 
 Username is set to: TOK-$APPNETA_SERVER_KEY
 
-Command should mimic:
-echo "$ACR_PASSWORD" | $DOCKER_CMD login -u "$ACR_USERNAME" --password-stdin "$ACR_REGISTRY"
-*/
-func SetupCompose(hostname, outDir string) {
-	dir := filepath.Join(outDir, hostname)
+Instead of piping the password to `docker login`, the credentials are verified via the Docker
+Engine API, stored in the Docker config file, and used to pull the images referenced by the compose
+file.
 
-	modifyCompose(filepath.Join(dir, "mp-compose.yaml"))
-	env := readEnv(filepath.Join(dir, ".env"))
-	un := "TOK-" + env.ServerKey
-	pw := getPassword(filepath.Join(dir, "tok.txt"))
-	reg := getRegistry(filepath.Join(dir, "setup.sh"))
+The credentials are stored in creds, which should be root's Docker config or a credential helper,
+since the appneta-cmp unit runs as root.
+
+If images is the path to an image tarball, the images are loaded from it instead. Since the registry
+may not be reachable, the credentials are stored without being verified.
+*/
+func SetupCompose(dir, images string, creds CredentialStore) {
+	auth := registryAuth(dir)
 
 	rsd := systemd.Root()
 	dockerRunning := rsd.CheckService("docker")
@@ -278,35 +191,23 @@ func SetupCompose(hostname, outDir string) {
 	if !dockerRunning {
 		rsd.StartService("docker")
 	}
-	util.Info("Logging in to AppNeta Docker registry %s as %s...", reg, un)
+	CheckEngine()
 
-	/* Docker CLI throws an error if using the --password argument, and prefers that the password
-	be piped in via stdin, with the --password-stdin argument.
-
-	See: https://docs.docker.com/engine/reference/commandline/login/#provide-a-password-using-stdin
-	*/
-	cmd := exec.Command("docker", "login", "--username", un, "--password-stdin", reg)
-	stdin, err := cmd.StdinPipe()
-	util.Check("Error accessing stdin for docker login command, which is required for inputting the password", err)
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-
-	err = cmd.Start()
-	util.Check("Error running command to log in to AppNeta Docker Registry", err)
-
-	io.WriteString(stdin, pw+"\n")
-	stdin.Close()
-
-	err = cmd.Wait()
-	util.Check("Error logging in to AppNeta Docker Registry %s:\n%v", err, reg, util.AsString(out.Bytes()))
+	if images != "" {
+		loadImages(images)
+		storeRegistryAuth(auth, creds)
+		util.Warning("Credentials for AppNeta Docker Registry %s were stored without being verified", auth.ServerAddress)
+		return
+	}
 
-	loggedIn := dockerLoginStatus(reg)
-	if !loggedIn {
-		util.Warning("AppNeta Docker setup completed, but %s is not logged into AppNeta Docker Registry %s", hostname, reg)
+	util.Info("Logging in to AppNeta Docker registry %s as %s...", auth.ServerAddress, auth.Username)
+	registryLogin(auth, creds)
+	if err := RegistryStatus(auth.ServerAddress, creds); err != nil {
+		util.Warning("AppNeta Docker setup completed, but the stored credentials for AppNeta Docker Registry %s could not be verified:\n%s", auth.ServerAddress, err.Error())
 	} else {
-		util.Success("Logged into AppNeta Docker Registry %s", reg)
+		util.Success("Logged into AppNeta Docker Registry %s", auth.ServerAddress)
 	}
+
+	pullImages(composeImages(dir), auth)
 	return
 }
@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	appneta "github.com/stellaraf/rmon-node-setup/appneta"
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+// ImagesFile is the name of the image tarball within an offline bundle.
+const ImagesFile string = "images.tar"
+
+// offlineExtractOptions allows for the size of the image tarball within an offline bundle.
+var offlineExtractOptions = util.ExtractOptions{MaxFileSize: 8 << 30, MaxTotalSize: 8 << 30}
+
+// loadImages loads images from a tarball created by `docker save` or Bundle.
+func loadImages(filename string) {
+	file, err := os.Open(filename)
+	util.Check("Error opening image tarball %s", err, filename)
+	defer file.Close()
+
+	util.Info("Loading images from %s...", filename)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	loaded, err := Engine().LoadImages(ctx, file)
+	util.Check("Error loading images from %s", err, filename)
+
+	for _, image := range loaded {
+		util.Success("Loaded image %s", image)
+	}
+}
+
+/*
+UseBundle extracts a pre-downloaded configuration bundle into workDir in place of GetCompose. The
+bundle may be downloaded from the AppNeta portal, or created by Bundle. The path of the extracted
+bundle is returned, along with the path of its image tarball, if it contains one.
+*/
+func UseBundle(bundle string, hostname string, workDir string) (dir string, images string) {
+	file, err := os.Open(bundle)
+	util.Check("Error opening AppNeta configuration bundle %s", err, bundle)
+
+	util.Info("Using AppNeta configuration bundle %s...", bundle)
+	dir = extractBundle(file, hostname, workDir, offlineExtractOptions)
+
+	if f := filepath.Join(dir, ImagesFile); util.FileExists(f) {
+		images = f
+	}
+	util.Success("Extracted AppNeta configuration bundle to %s", dir)
+	return
+}
+
+/*
+Bundle creates an offline bundle for use with `install --appneta-bundle` on a node without access
+to the AppNeta portal or registry. The configuration for hostname is downloaded from AppNeta, the
+images it references are pulled & saved, and both are written to output as a single gzipped
+tarball. The images are pulled by the local Docker daemon, so Docker must be running.
+*/
+func Bundle(client *appneta.Client, hostname string, output string) {
+	dir := GetCompose(client, hostname, util.PrivateTempDir("rmon-bundle-"))
+
+	CheckEngine()
+	images := composeImages(dir)
+	pullImages(images, registryAuth(dir))
+
+	imagesFile := filepath.Join(dir, ImagesFile)
+	util.Info("Saving images %s...", strings.Join(images, ", "))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	saved, err := Engine().SaveImages(ctx, images)
+	util.Check("Error saving images: ", err)
+	defer saved.Close()
+
+	out, err := os.OpenFile(imagesFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	util.Check("Error creating %s", err, imagesFile)
+	_, err = io.Copy(out, saved)
+	util.Check("Error writing %s", err, imagesFile)
+	util.Check("Error writing %s", out.Close(), imagesFile)
+
+	bundle, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	util.Check("Error creating bundle %s", err, output)
+	defer bundle.Close()
+	err = util.WriteArchive(bundle, dir)
+	util.Check("Error writing bundle %s", err, output)
+	util.Check("Error writing bundle %s", bundle.Sync(), output)
+
+	util.Success("Wrote offline bundle for %s to %s", hostname, output)
+}
@@ -0,0 +1,387 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// APIVersion is the Docker Engine API version requested by Client. 1.40 is supported by Docker
+// 19.03 and newer.
+const APIVersion string = "1.40"
+
+// Client is a minimal Docker Engine API client which talks to the daemon over its unix socket.
+type Client struct {
+	// Socket is the path to the Docker daemon's unix socket.
+	Socket string
+	// HTTP is the underlying HTTP client. Its transport dials Socket regardless of the request
+	// host, so any HTTP server listening on that socket (including a test server) can be used.
+	HTTP *http.Client
+}
+
+// EngineError is the JSON response received from the Docker Engine API if there is an error with
+// the request.
+type EngineError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+}
+
+func (e *EngineError) Error() string {
+	return fmt.Sprintf("docker engine returned %d: %s", e.StatusCode, e.Message)
+}
+
+// VersionResponse is the response from the Engine API's /version endpoint.
+type VersionResponse struct {
+	Version       string `json:"Version"`
+	APIVersion    string `json:"ApiVersion"`
+	MinAPIVersion string `json:"MinAPIVersion"`
+	GitCommit     string `json:"GitCommit"`
+	GoVersion     string `json:"GoVersion"`
+	Os            string `json:"Os"`
+	Arch          string `json:"Arch"`
+	KernelVersion string `json:"KernelVersion"`
+}
+
+// AuthConfig holds registry credentials, as sent to /auth or encoded into X-Registry-Auth.
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// AuthResponse is the response from the Engine API's /auth endpoint.
+type AuthResponse struct {
+	Status        string `json:"Status"`
+	IdentityToken string `json:"IdentityToken"`
+}
+
+// ProgressMessage is a single JSON message from a streaming Engine API endpoint such as an image
+// pull.
+type ProgressMessage struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// ContainerState is the State object of an inspected container.
+type ContainerState struct {
+	Status     string `json:"Status"`
+	Running    bool   `json:"Running"`
+	Restarting bool   `json:"Restarting"`
+	ExitCode   int    `json:"ExitCode"`
+	Error      string `json:"Error"`
+	StartedAt  string `json:"StartedAt"`
+	Health     *struct {
+		Status string `json:"Status"`
+	} `json:"Health"`
+}
+
+// ContainerJSON is the response from the Engine API's /containers/{id}/json endpoint.
+type ContainerJSON struct {
+	ID           string         `json:"Id"`
+	Name         string         `json:"Name"`
+	Image        string         `json:"Image"`
+	Created      string         `json:"Created"`
+	RestartCount int            `json:"RestartCount"`
+	State        ContainerState `json:"State"`
+	Config       struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// ContainerSummary is a single item of the Engine API's /containers/json response.
+type ContainerSummary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// NewClient creates a Docker Engine API client for the daemon listening on socket.
+func NewClient(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Client{Socket: socket, HTTP: &http.Client{Transport: transport}}
+}
+
+// EncodeAuth encodes registry credentials for the X-Registry-Auth header.
+func EncodeAuth(auth AuthConfig) (string, error) {
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	u := url.URL{Scheme: "http", Host: "docker", Path: "/v" + APIVersion + path}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+/*
+do sends a request to the Engine API. If the daemon responds with an error status, the body is
+decoded into an EngineError and closed. Otherwise, the caller is responsible for closing the
+response body.
+*/
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}, headers map[string]string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.url(path, query), reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode > 399 {
+		defer res.Body.Close()
+		return nil, decodeError(res)
+	}
+	return res, nil
+}
+
+// decodeError reads an EngineError from an error response.
+func decodeError(res *http.Response) error {
+	engineErr := &EngineError{StatusCode: res.StatusCode}
+	b, _ := ioutil.ReadAll(res.Body)
+	if json.Unmarshal(b, engineErr) != nil || engineErr.Message == "" {
+		engineErr.Message = strings.TrimSpace(string(b))
+	}
+	return engineErr
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	res, err := c.do(ctx, http.MethodGet, path, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Ping checks that the Docker daemon is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	res, err := c.do(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// WaitReady pings the Docker daemon until it responds or timeout elapses.
+func (c *Client) WaitReady(timeout time.Duration) (err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = c.Ping(ctx)
+		cancel()
+		if err == nil || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// Version gets the version information of the Docker daemon.
+func (c *Client) Version(ctx context.Context) (v VersionResponse, err error) {
+	err = c.getJSON(ctx, "/version", nil, &v)
+	return
+}
+
+// Auth validates registry credentials. The daemon contacts the registry to verify them.
+func (c *Client) Auth(ctx context.Context, auth AuthConfig) (a AuthResponse, err error) {
+	res, err := c.do(ctx, http.MethodPost, "/auth", nil, auth, nil)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	err = json.NewDecoder(res.Body).Decode(&a)
+	return
+}
+
+/*
+PullImage pulls an image by reference, e.g. registry.example.com/repo/image:tag. If auth is not nil,
+it is sent to the daemon as the registry credentials for the pull. Each progress message is passed
+to progress, if provided. An error reported mid-stream by the daemon is returned as an EngineError.
+*/
+func (c *Client) PullImage(ctx context.Context, ref string, auth *AuthConfig, progress func(ProgressMessage)) error {
+	image, tag := splitReference(ref)
+	query := url.Values{"fromImage": {image}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+
+	headers := map[string]string{}
+	if auth != nil {
+		encoded, err := EncodeAuth(*auth)
+		if err != nil {
+			return err
+		}
+		headers["X-Registry-Auth"] = encoded
+	}
+
+	res, err := c.do(ctx, http.MethodPost, "/images/create", query, nil, headers)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var msg ProgressMessage
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Error != "" || msg.ErrorDetail.Message != "" {
+			m := msg.ErrorDetail.Message
+			if m == "" {
+				m = msg.Error
+			}
+			return &EngineError{StatusCode: res.StatusCode, Message: m}
+		}
+		if progress != nil {
+			progress(msg)
+		}
+	}
+}
+
+/*
+LoadImages loads images from a tarball created by `docker save` or SaveImages, the same way as
+`docker load`. The names of the loaded images are returned.
+*/
+func (c *Client) LoadImages(ctx context.Context, r io.Reader) (loaded []string, err error) {
+	req, err := http.NewRequest(http.MethodPost, c.url("/images/load", url.Values{"quiet": {"1"}}), r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 399 {
+		return nil, decodeError(res)
+	}
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var msg struct {
+			ProgressMessage
+			Stream string `json:"stream"`
+		}
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if msg.Error != "" || msg.ErrorDetail.Message != "" {
+			m := msg.ErrorDetail.Message
+			if m == "" {
+				m = msg.Error
+			}
+			return loaded, &EngineError{StatusCode: res.StatusCode, Message: m}
+		}
+		// e.g. "Loaded image: registry.example.com/repo/image:tag\n"
+		if s := strings.TrimSpace(msg.Stream); strings.HasPrefix(s, "Loaded image") {
+			loaded = append(loaded, strings.TrimSpace(s[strings.Index(s, ":")+1:]))
+		}
+	}
+}
+
+/*
+SaveImages exports images & their tags as a tarball, the same way as `docker save`. The caller must
+close the returned reader.
+*/
+func (c *Client) SaveImages(ctx context.Context, names []string) (io.ReadCloser, error) {
+	res, err := c.do(ctx, http.MethodGet, "/images/get", url.Values{"names": names}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// ListContainers lists all containers, running or not, matching filters.
+func (c *Client) ListContainers(ctx context.Context, filters map[string][]string) (containers []ContainerSummary, err error) {
+	query := url.Values{"all": {"true"}}
+	if len(filters) > 0 {
+		f, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(f))
+	}
+	err = c.getJSON(ctx, "/containers/json", query, &containers)
+	return
+}
+
+// InspectContainer gets low-level information about a container by name or ID.
+func (c *Client) InspectContainer(ctx context.Context, name string) (container ContainerJSON, err error) {
+	err = c.getJSON(ctx, "/containers/"+url.PathEscape(name)+"/json", nil, &container)
+	return
+}
+
+/*
+splitReference splits an image reference into its name and tag. A colon is only treated as a tag
+separator if it is after the last slash, so that registry ports are left alone. Digest references
+are returned whole.
+*/
+func splitReference(ref string) (name string, tag string) {
+	if strings.Contains(ref, "@") {
+		return ref, ""
+	}
+	slash := strings.LastIndex(ref, "/")
+	colon := strings.LastIndex(ref, ":")
+	if colon > slash {
+		return ref[:colon], ref[colon+1:]
+	}
+	return ref, "latest"
+}
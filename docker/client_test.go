@@ -0,0 +1,197 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+newTestEngine serves a fake Engine API on a unix socket, and creates a Client which talks to it. The
+returned function stops the server.
+*/
+func newTestEngine(t *testing.T, handler http.Handler) (*Client, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "docker-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	return NewClient(socket), func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 10*time.Second)
+}
+
+func TestClientVersion(t *testing.T) {
+	c, stop := newTestEngine(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v"+APIVersion+"/version" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"Version": "24.0.7", "ApiVersion": "1.43", "Arch": "arm64"})
+	}))
+	defer stop()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	v, err := c.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "24.0.7" || v.APIVersion != "1.43" || v.Arch != "arm64" {
+		t.Errorf("unexpected version %+v", v)
+	}
+}
+
+func TestClientPing(t *testing.T) {
+	c, stop := newTestEngine(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v"+APIVersion+"/_ping" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer stop()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitReady(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientPingUnreachable(t *testing.T) {
+	c := NewClient(filepath.Join(os.TempDir(), "docker-test-missing.sock"))
+	ctx, cancel := testContext()
+	defer cancel()
+	if err := c.Ping(ctx); err == nil {
+		t.Fatal("expected an error from a missing socket")
+	}
+}
+
+func TestClientInspectMissingContainer(t *testing.T) {
+	c, stop := newTestEngine(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v"+APIVersion+"/containers/appneta/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such container: appneta"}`))
+	}))
+	defer stop()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	_, err := c.InspectContainer(ctx, "appneta")
+	var engineErr *EngineError
+	if !errors.As(err, &engineErr) {
+		t.Fatalf("expected an EngineError, got %v", err)
+	}
+	if engineErr.StatusCode != http.StatusNotFound || engineErr.Message != "No such container: appneta" {
+		t.Errorf("unexpected error %+v", engineErr)
+	}
+}
+
+func TestClientErrorBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		message string
+	}{
+		{"json", http.StatusInternalServerError, `{"message":"layer does not exist"}`, "layer does not exist"},
+		{"plain text", http.StatusBadGateway, "bad gateway\n", "bad gateway"},
+		{"json without a message", http.StatusConflict, `{"error":"conflict"}`, `{"error":"conflict"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, stop := newTestEngine(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer stop()
+			ctx, cancel := testContext()
+			defer cancel()
+
+			_, err := c.Version(ctx)
+			var engineErr *EngineError
+			if !errors.As(err, &engineErr) {
+				t.Fatalf("expected an EngineError, got %v", err)
+			}
+			if engineErr.StatusCode != tt.status || engineErr.Message != tt.message {
+				t.Errorf("got %d %q, want %d %q", engineErr.StatusCode, engineErr.Message, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+func TestClientPullImageStreamError(t *testing.T) {
+	c, stop := newTestEngine(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("fromImage"); got != "registry.example.com:5000/appneta/mp" {
+			t.Errorf("unexpected fromImage %s", got)
+		}
+		if got := r.URL.Query().Get("tag"); got != "1.2" {
+			t.Errorf("unexpected tag %s", got)
+		}
+		if r.Header.Get("X-Registry-Auth") == "" {
+			t.Error("X-Registry-Auth was not sent")
+		}
+		w.Write([]byte(`{"status":"Pulling from appneta/mp","id":"1.2"}` + "\n"))
+		w.Write([]byte(`{"errorDetail":{"message":"unauthorized"},"error":"unauthorized"}` + "\n"))
+	}))
+	defer stop()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	var messages []ProgressMessage
+	err := c.PullImage(ctx, "registry.example.com:5000/appneta/mp:1.2", &AuthConfig{Username: "u", Password: "p"}, func(m ProgressMessage) {
+		messages = append(messages, m)
+	})
+	var engineErr *EngineError
+	if !errors.As(err, &engineErr) || engineErr.Message != "unauthorized" {
+		t.Fatalf("expected an unauthorized EngineError, got %v", err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("expected 1 progress message before the error, got %d", len(messages))
+	}
+}
+
+func TestSplitReference(t *testing.T) {
+	tests := []struct {
+		ref, name, tag string
+	}{
+		{"alpine", "alpine", "latest"},
+		{"alpine:3.18", "alpine", "3.18"},
+		{"registry.example.com:5000/appneta/mp", "registry.example.com:5000/appneta/mp", "latest"},
+		{"registry.example.com:5000/appneta/mp:1.2", "registry.example.com:5000/appneta/mp", "1.2"},
+		{"alpine@sha256:abc", "alpine@sha256:abc", ""},
+	}
+	for _, tt := range tests {
+		name, tag := splitReference(tt.ref)
+		if name != tt.name || tag != tt.tag {
+			t.Errorf("splitReference(%q) = %q, %q, want %q, %q", tt.ref, name, tag, tt.name, tt.tag)
+		}
+	}
+}
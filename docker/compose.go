@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	g "github.com/stellaraf/rmon-node-setup/globals"
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+const (
+	// ComposeAuto prefers the Docker Compose v2 plugin, and falls back to v1.
+	ComposeAuto string = "auto"
+	// ComposeV1 forces the standalone, pip-installed docker-compose (v1).
+	ComposeV1 string = "v1"
+	// ComposeV2 forces the `docker compose` plugin (v2).
+	ComposeV2 string = "v2"
+)
+
+// Compose describes a Docker Compose installation & how to invoke it.
+type Compose struct {
+	// Version is either ComposeV1 or ComposeV2.
+	Version string
+	// Bin is the absolute path to the binary, i.e. docker for v2 or docker-compose for v1.
+	Bin string
+	// Args are the arguments that precede any compose command, i.e. `compose` for v2.
+	Args []string
+}
+
+// Command gets the full command used to invoke Docker Compose, e.g. `/usr/bin/docker compose`.
+func (c Compose) Command() string {
+	return strings.Join(append([]string{c.Bin}, c.Args...), " ")
+}
+
+// Exec creates a command which runs Docker Compose with args.
+func (c Compose) Exec(args ...string) *exec.Cmd {
+	return exec.Command(c.Bin, append(c.Args, args...)...)
+}
+
+// InstalledVersion gets the version of Docker Compose, e.g. 2.21.0.
+func (c Compose) InstalledVersion() (string, error) {
+	out, err := c.Exec("version", "--short").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(util.AsString(out), "v"), nil
+}
+
+// ValidComposeMode determines if m is a valid Docker Compose mode.
+func ValidComposeMode(m string) bool {
+	return m == ComposeAuto || m == ComposeV1 || m == ComposeV2
+}
+
+func composeV2() (c Compose, found bool) {
+	bin, err := exec.LookPath("docker")
+	if err != nil {
+		return
+	}
+	if err = exec.Command(bin, "compose", "version").Run(); err != nil {
+		return
+	}
+	return Compose{Version: ComposeV2, Bin: bin, Args: []string{"compose"}}, true
+}
+
+func composeV1() (c Compose, found bool) {
+	candidates := []string{
+		"/usr/local/bin/docker-compose",
+		filepath.Join(g.UserHome(), ".local/bin/docker-compose"),
+		"/usr/bin/docker-compose",
+	}
+	if bin, err := exec.LookPath("docker-compose"); err == nil {
+		candidates = append([]string{bin}, candidates...)
+	}
+	for _, bin := range candidates {
+		if !util.FileExists(bin) {
+			continue
+		}
+		if err := exec.Command(bin, "version").Run(); err == nil {
+			return Compose{Version: ComposeV1, Bin: bin}, true
+		}
+	}
+	return
+}
+
+// DetectCompose finds an installed Docker Compose matching mode.
+func DetectCompose(mode string) (c Compose, found bool) {
+	switch mode {
+	case ComposeV1:
+		return composeV1()
+	case ComposeV2:
+		return composeV2()
+	}
+	if c, found = composeV2(); found {
+		return
+	}
+	return composeV1()
+}
+
+func installComposeV2() error {
+	_, err := util.EnsurePackages("docker-compose-plugin")
+	return err
+}
+
+func installComposeV1() error {
+	out, err := exec.Command("pip3", "install", "docker-compose").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v\n%s", err, util.AsString(out))
+	}
+	return nil
+}
+
+/*
+InstallCompose installs Docker Compose if it is not already installed. In auto mode, the v2 plugin
+is installed from the Docker APT repository, and if that fails, v1 is installed via pip.
+*/
+func InstallCompose(mode string) Compose {
+	if c, found := DetectCompose(mode); found {
+		util.Info("Using Docker Compose %s (%s)", c.Version, c.Command())
+		return c
+	}
+
+	util.Info("Docker Compose is not installed. Installing...")
+	switch mode {
+	case ComposeV1:
+		util.Check("Error installing Docker Compose v1: ", installComposeV1())
+	case ComposeV2:
+		util.Check("Error installing Docker Compose v2 plugin: ", installComposeV2())
+	default:
+		if err := installComposeV2(); err != nil {
+			util.Warning("Unable to install Docker Compose v2 plugin, falling back to v1:\n%v", err)
+			util.Check("Error installing Docker Compose v1: ", installComposeV1())
+		}
+	}
+
+	c, found := DetectCompose(mode)
+	if !found {
+		util.Critical("Docker Compose was installed, but could not be found")
+		util.Exit(1)
+	}
+	util.Success("Installed Docker Compose %s (%s)", c.Version, c.Command())
+	return c
+}
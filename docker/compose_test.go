@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	g "github.com/stellaraf/rmon-node-setup/globals"
+)
+
+/*
+fakeBins creates executable scripts in a temporary directory, and makes it the only directory in
+PATH. The returned function restores PATH & removes the directory.
+*/
+func fakeBins(t *testing.T, scripts map[string]string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "compose-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path, home := os.Getenv("PATH"), g.LocalHome
+	os.Setenv("PATH", dir)
+	g.LocalHome = dir
+	return dir, func() {
+		os.Setenv("PATH", path)
+		g.LocalHome = home
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDetectCompose(t *testing.T) {
+	for _, bin := range []string{"/usr/local/bin/docker-compose", "/usr/bin/docker-compose"} {
+		if _, err := os.Stat(bin); err == nil {
+			t.Skipf("%s is installed", bin)
+		}
+	}
+
+	const (
+		v2     = `[ "$1" = compose ] && exit 0; exit 1`
+		noV2   = `exit 1`
+		v1     = `[ "$1" = version ] && exit 0; exit 1`
+		broken = `exit 1`
+	)
+	tests := []struct {
+		name    string
+		scripts map[string]string
+		mode    string
+		found   bool
+		version string
+		bin     string
+	}{
+		{"auto prefers v2", map[string]string{"docker": v2, "docker-compose": v1}, ComposeAuto, true, ComposeV2, "docker"},
+		{"auto falls back to v1", map[string]string{"docker": noV2, "docker-compose": v1}, ComposeAuto, true, ComposeV1, "docker-compose"},
+		{"auto without docker", map[string]string{"docker-compose": v1}, ComposeAuto, true, ComposeV1, "docker-compose"},
+		{"v1 forced", map[string]string{"docker": v2, "docker-compose": v1}, ComposeV1, true, ComposeV1, "docker-compose"},
+		{"v2 forced", map[string]string{"docker": noV2, "docker-compose": v1}, ComposeV2, false, "", ""},
+		{"broken v1", map[string]string{"docker-compose": broken}, ComposeAuto, false, "", ""},
+		{"none", map[string]string{}, ComposeAuto, false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := fakeBins(t, tt.scripts)
+			defer cleanup()
+
+			c, found := DetectCompose(tt.mode)
+			if found != tt.found {
+				t.Fatalf("found = %v, want %v", found, tt.found)
+			}
+			if !found {
+				return
+			}
+			if c.Version != tt.version || c.Bin != filepath.Join(dir, tt.bin) {
+				t.Errorf("got %s %s, want %s %s", c.Version, c.Bin, tt.version, tt.bin)
+			}
+			if c.Version == ComposeV2 && c.Command() != filepath.Join(dir, "docker")+" compose" {
+				t.Errorf("unexpected command %s", c.Command())
+			}
+		})
+	}
+}
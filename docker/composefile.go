@@ -0,0 +1,617 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	util "github.com/stellaraf/rmon-node-setup/util"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+/*
+ComposeFile is a parsed docker-compose file. Transforms edit its YAML node tree in place, so the order
+of keys & any comments are kept when it is written back out.
+*/
+type ComposeFile struct {
+	doc *yaml.Node
+}
+
+// ComposeTransform is a single modification applied to a docker-compose file.
+type ComposeTransform func(*ComposeFile) error
+
+// ComposeOptions are the modifications made to the AppNeta docker-compose file.
+type ComposeOptions struct {
+	ContainerName string
+	Labels        map[string]string
+	Restart       string
+	LogMaxSize    string
+	LogMaxFile    int
+	CPUs          string
+	Memory        string
+}
+
+// Transforms gets the transforms which apply the options.
+func (o ComposeOptions) Transforms() []ComposeTransform {
+	t := []ComposeTransform{DropNetworkMode()}
+	if o.ContainerName != "" {
+		t = append(t, SetContainerName(o.ContainerName))
+	}
+	if len(o.Labels) > 0 {
+		t = append(t, AddLabels(o.Labels))
+	}
+	if o.Restart != "" {
+		t = append(t, RestartPolicy(o.Restart))
+	}
+	if o.LogMaxSize != "" {
+		t = append(t, LoggingLimits(o.LogMaxSize, o.LogMaxFile))
+	}
+	return append(t, ResourceLimits(o.CPUs, o.Memory))
+}
+
+var restartPolicies = []string{"no", "always", "on-failure", "unless-stopped"}
+
+// ParseCompose parses the contents of a docker-compose file.
+func ParseCompose(b []byte) (*ComposeFile, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, errors.New("docker-compose file is empty")
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("docker-compose file is not a mapping")
+	}
+	return &ComposeFile{doc: &doc}, nil
+}
+
+// LoadCompose reads & parses a docker-compose file.
+func LoadCompose(filename string) (*ComposeFile, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCompose(b)
+}
+
+// composeService is a service definition of a docker-compose file.
+type composeService struct {
+	name string
+	node *yaml.Node
+}
+
+// services gets each service definition which is a mapping, in the order of the file.
+func (c *ComposeFile) services() (services []composeService) {
+	raw := lookup(c.doc.Content[0], "services")
+	if raw == nil || raw.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(raw.Content); i += 2 {
+		if svc := resolve(raw.Content[i+1]); svc.Kind == yaml.MappingNode {
+			services = append(services, composeService{raw.Content[i].Value, svc})
+		}
+	}
+	return
+}
+
+// Images gets the image reference of each service, sorted.
+func (c *ComposeFile) Images() (images []string) {
+	for _, svc := range c.services() {
+		if image := scalar(lookup(svc.node, "image")); image != "" {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return
+}
+
+// Apply applies each transform in order.
+func (c *ComposeFile) Apply(transforms ...ComposeTransform) error {
+	for _, t := range transforms {
+		if err := t(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks that the structure of the docker-compose file is usable by appneta-cmp.
+func (c *ComposeFile) Validate() error {
+	raw := lookup(c.doc.Content[0], "services")
+	if raw == nil || raw.Kind != yaml.MappingNode || len(raw.Content) == 0 {
+		return errors.New("no services are defined")
+	}
+	for i := 0; i+1 < len(raw.Content); i += 2 {
+		name, svc := raw.Content[i].Value, resolve(raw.Content[i+1])
+		if svc.Kind != yaml.MappingNode {
+			return fmt.Errorf("service %s is not a mapping", name)
+		}
+		if scalar(lookup(svc, "image")) == "" {
+			return fmt.Errorf("service %s has no image", name)
+		}
+		if scalar(lookup(svc, "network_mode")) == "host" {
+			return fmt.Errorf("service %s uses host networking", name)
+		}
+		if cn := lookup(svc, "container_name"); cn != nil {
+			if err := ValidateContainerName(scalar(cn)); err != nil {
+				return fmt.Errorf("service %s has an invalid container name: %v", name, err)
+			}
+		}
+		if restart := lookup(svc, "restart"); restart != nil && !validRestart(scalar(restart)) {
+			return fmt.Errorf("service %s has an invalid restart policy '%s'", name, scalar(restart))
+		}
+	}
+	// Ensure the output can be read back in.
+	b, err := c.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = ParseCompose(b)
+	return err
+}
+
+// Marshal serializes the docker-compose file to YAML.
+func (c *ComposeFile) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write validates & writes the docker-compose file to filename.
+func (c *ComposeFile) Write(filename string, perm os.FileMode) error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid docker-compose file: %v", err)
+	}
+	b, err := c.Marshal()
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(filename, b, util.FileOptions{Perm: perm})
+}
+
+func validRestart(policy string) bool {
+	for _, p := range restartPolicies {
+		if policy == p || (p == "on-failure" && strings.HasPrefix(policy, "on-failure:")) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+majorVersion gets the major version of the compose file format. Files without a version key are
+treated as version 3, which is what the Compose Specification is compatible with.
+*/
+func (c *ComposeFile) majorVersion() string {
+	v := lookup(c.doc.Content[0], "version")
+	if v == nil {
+		return "3"
+	}
+	return strings.SplitN(scalar(v), ".", 2)[0]
+}
+
+// resolve gets the node an alias refers to, or n itself if it isn't an alias.
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// scalar gets the value of a scalar node, or "" if n is nil or not a scalar.
+func scalar(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return n.Value
+}
+
+// lookup gets the value of key in the mapping m, or nil if it does not exist.
+func lookup(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return resolve(m.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// set sets the value of key in the mapping m, adding it after the existing keys if it does not exist.
+func set(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// setString sets key in the mapping m to a string. An existing scalar is changed in place, which
+// keeps its comments.
+func setString(m *yaml.Node, key string, value string) {
+	if existing := lookup(m, key); existing != nil && existing.Kind == yaml.ScalarNode {
+		existing.Tag, existing.Value = "!!str", value
+		return
+	}
+	set(m, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// remove removes key from the mapping m.
+func remove(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// mapping gets a nested mapping from m by key, creating it if it does not exist.
+func mapping(m *yaml.Node, key string) *yaml.Node {
+	if existing := lookup(m, key); existing != nil && existing.Kind == yaml.MappingNode {
+		return existing
+	}
+	created := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	set(m, key, created)
+	return created
+}
+
+func eachService(f func(name string, svc *yaml.Node) error) ComposeTransform {
+	return func(c *ComposeFile) error {
+		services := c.services()
+		if len(services) == 0 {
+			return errors.New("no services are defined")
+		}
+		for _, svc := range services {
+			if err := f(svc.name, svc.node); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+/*
+DropNetworkMode removes network_mode from every service. This is the equivalent of line 92 of the
+AppNeta bash script:
+
+	sed -i '/network_mode:\ host/d' mp-compose.yaml
+*/
+func DropNetworkMode() ComposeTransform {
+	return eachService(func(_ string, svc *yaml.Node) error {
+		remove(svc, "network_mode")
+		return nil
+	})
+}
+
+// containerNameFlag is the argument of the AppNeta container's command which names the container.
+const containerNameFlag string = "--containername"
+
+// hasContainerNameArg determines if a service's command has a --containername argument.
+func hasContainerNameArg(svcName string, svc *yaml.Node) (bool, error) {
+	cmd := lookup(svc, "command")
+	if cmd == nil {
+		return false, nil
+	}
+	var args []string
+	switch cmd.Kind {
+	case yaml.ScalarNode:
+		words, err := splitShellWords(cmd.Value)
+		if err != nil {
+			return false, fmt.Errorf("service %s has an invalid command: %v", svcName, err)
+		}
+		for _, w := range words {
+			args = append(args, w.value)
+		}
+	case yaml.SequenceNode:
+		for _, arg := range cmd.Content {
+			args = append(args, arg.Value)
+		}
+	}
+	for _, arg := range args {
+		if arg == containerNameFlag || strings.HasPrefix(arg, containerNameFlag+"=") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+appNetaService finds the AppNeta service, which is the one whose command has a --containername
+argument, or the only service if none do. Container names are unique, so it's an error if several
+services have the argument, or if none do & there are several services.
+*/
+func (c *ComposeFile) appNetaService() (composeService, error) {
+	services := c.services()
+	if len(services) == 0 {
+		return composeService{}, errors.New("no services are defined")
+	}
+	var found []composeService
+	for _, svc := range services {
+		has, err := hasContainerNameArg(svc.name, svc.node)
+		if err != nil {
+			return composeService{}, err
+		}
+		if has {
+			found = append(found, svc)
+		}
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case len(found) > 1:
+		return composeService{}, fmt.Errorf("services %s & %s both have a %s argument, so the AppNeta service is ambiguous", found[0].name, found[1].name, containerNameFlag)
+	case len(services) == 1:
+		return services[0], nil
+	}
+	return composeService{}, fmt.Errorf("none of the %d services has a %s argument, so the AppNeta service can't be found", len(services), containerNameFlag)
+}
+
+/*
+SetContainerName sets the container name of the AppNeta service (see appNetaService), and replaces
+the value of the --containername argument in its command. The latter is the equivalent of line 93 of
+the AppNeta bash script:
+
+	sed -i 's/containername\ localhost/containername\ talos-001/g' mp-compose.yaml
+
+Only the argument's value is replaced, so the rest of a command string, e.g. its quoting, is kept as-is.
+Other services are left as-is, since they can't have the same container name.
+*/
+func SetContainerName(name string) ComposeTransform {
+	return func(c *ComposeFile) error {
+		svc, err := c.appNetaService()
+		if err != nil {
+			return err
+		}
+		setString(svc.node, "container_name", name)
+		cmd := lookup(svc.node, "command")
+		if cmd == nil {
+			return nil
+		}
+		switch cmd.Kind {
+		case yaml.ScalarNode:
+			replaced, err := replaceShellArg(cmd.Value, containerNameFlag, name)
+			if err != nil {
+				return fmt.Errorf("service %s has an invalid command: %v", svc.name, err)
+			}
+			cmd.Value = replaced
+		case yaml.SequenceNode:
+			for i, arg := range cmd.Content {
+				switch {
+				case arg.Value == containerNameFlag && i+1 < len(cmd.Content):
+					cmd.Content[i+1].Value = name
+				case strings.HasPrefix(arg.Value, containerNameFlag+"="):
+					arg.Value = containerNameFlag + "=" + name
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// AddLabels adds labels to every service, overwriting any existing labels with the same key.
+func AddLabels(labels map[string]string) ComposeTransform {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return eachService(func(name string, svc *yaml.Node) error {
+		existing := lookup(svc, "labels")
+		if existing == nil || (existing.Kind == yaml.ScalarNode && existing.Tag == "!!null") {
+			existing = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			set(svc, "labels", existing)
+		}
+		switch existing.Kind {
+		case yaml.MappingNode:
+			for _, k := range keys {
+				setString(existing, k, labels[k])
+			}
+		case yaml.SequenceNode:
+			// Labels may also be specified as a list of key=value strings.
+		next:
+			for _, k := range keys {
+				item := k + "=" + labels[k]
+				for _, n := range existing.Content {
+					if strings.SplitN(n.Value, "=", 2)[0] == k {
+						n.Value = item
+						continue next
+					}
+				}
+				existing.Content = append(existing.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+			}
+		default:
+			return fmt.Errorf("service %s has invalid labels", name)
+		}
+		return nil
+	})
+}
+
+// RestartPolicy sets the restart policy of every service.
+func RestartPolicy(policy string) ComposeTransform {
+	return func(c *ComposeFile) error {
+		if !validRestart(policy) {
+			return fmt.Errorf("invalid restart policy '%s'", policy)
+		}
+		return eachService(func(_ string, svc *yaml.Node) error {
+			setString(svc, "restart", policy)
+			return nil
+		})(c)
+	}
+}
+
+/*
+LoggingLimits configures every service to use the json-file log driver with rotation. The options of
+any other log driver are removed, as they aren't valid for json-file.
+*/
+func LoggingLimits(maxSize string, maxFile int) ComposeTransform {
+	return eachService(func(_ string, svc *yaml.Node) error {
+		logging := mapping(svc, "logging")
+		if driver := scalar(lookup(logging, "driver")); driver != "" && driver != "json-file" {
+			remove(logging, "options")
+		}
+		setString(logging, "driver", "json-file")
+		options := mapping(logging, "options")
+		setString(options, "max-size", maxSize)
+		setString(options, "max-file", strconv.Itoa(maxFile))
+		return nil
+	})
+}
+
+/*
+ResourceLimits sets the CPU & memory limits of every service. Empty values are left unset. Version 2
+compose files use the service-level cpus & mem_limit keys, and all others use deploy.resources.
+*/
+func ResourceLimits(cpus string, memory string) ComposeTransform {
+	return func(c *ComposeFile) error {
+		if cpus == "" && memory == "" {
+			return nil
+		}
+		v2 := c.majorVersion() == "2"
+		return eachService(func(_ string, svc *yaml.Node) error {
+			if v2 {
+				if cpus != "" {
+					setString(svc, "cpus", cpus)
+				}
+				if memory != "" {
+					setString(svc, "mem_limit", memory)
+				}
+				return nil
+			}
+			limits := mapping(mapping(mapping(svc, "deploy"), "resources"), "limits")
+			if cpus != "" {
+				setString(limits, "cpus", cpus)
+			}
+			if memory != "" {
+				setString(limits, "memory", memory)
+			}
+			return nil
+		})(c)
+	}
+}
+
+/*
+shellWord is a word of a command line, with the offsets of its start & end in the command line,
+including any quotes.
+*/
+type shellWord struct {
+	value      string
+	start, end int
+}
+
+/*
+splitShellWords splits a command line into words the way a POSIX shell does, which is how Compose
+splits a command string: words are separated by unquoted whitespace, and may be quoted with single
+quotes, double quotes or backslashes.
+*/
+func splitShellWords(s string) (words []shellWord, err error) {
+	var buf strings.Builder
+	inWord, start := false, 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == ' ' || ch == '\t' || ch == '\n' {
+			if inWord {
+				words = append(words, shellWord{buf.String(), start, i})
+				buf.Reset()
+				inWord = false
+			}
+			continue
+		}
+		if !inWord {
+			inWord, start = true, i
+		}
+		switch ch {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				if s[i] != '\n' {
+					buf.WriteByte(s[i])
+				}
+			}
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			buf.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				buf.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	if inWord {
+		words = append(words, shellWord{buf.String(), start, len(s)})
+	}
+	return
+}
+
+// safeShellWord matches words which don't need to be quoted.
+var safeShellWord = regexp.MustCompile(`^[a-zA-Z0-9_.,:/@%+=-]+$`)
+
+// shellQuote quotes a word for a POSIX shell, if it needs to be.
+func shellQuote(s string) string {
+	if safeShellWord.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+/*
+replaceShellArg replaces the value of each flag argument in a command line, given either as
+`flag value` or `flag=value`. The rest of the command line is unchanged.
+*/
+func replaceShellArg(cmd string, flag string, value string) (string, error) {
+	words, err := splitShellWords(cmd)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	last := 0
+	for i, w := range words {
+		switch {
+		case w.value == flag && i+1 < len(words):
+			b.WriteString(cmd[last:words[i+1].start])
+			b.WriteString(shellQuote(value))
+			last = words[i+1].end
+		case strings.HasPrefix(w.value, flag+"="):
+			b.WriteString(cmd[last:w.start])
+			b.WriteString(shellQuote(flag + "=" + value))
+			last = w.end
+		}
+	}
+	b.WriteString(cmd[last:])
+	return b.String(), nil
+}
@@ -0,0 +1,246 @@
+package docker
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testOptions are the options applied to each fixture compose file.
+var testOptions = ComposeOptions{
+	ContainerName: "rpi01-rmon-orion-cloud",
+	Labels:        map[string]string{"com.appneta.role": "rmon", "cloud.orion.node-id": "01"},
+	Restart:       "unless-stopped",
+	LogMaxSize:    "10m",
+	LogMaxFile:    3,
+	CPUs:          "1.5",
+	Memory:        "512m",
+}
+
+func loadFixture(t *testing.T, name string) *ComposeFile {
+	t.Helper()
+	c, err := LoadCompose(filepath.Join("testdata", "compose", name+".yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestComposeMajorVersion(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    string
+	}{
+		{"v2", "2"},
+		{"v3", "3"},
+		{"spec", "3"},
+		{"multi", "3"},
+	}
+	for _, tt := range tests {
+		if got := loadFixture(t, tt.fixture).majorVersion(); got != tt.want {
+			t.Errorf("%s: got version %s, want %s", tt.fixture, got, tt.want)
+		}
+	}
+}
+
+/*
+TestComposeTransforms applies testOptions to each fixture, and compares the output with the
+fixture's golden file. Run `go test ./docker -update` to regenerate the golden files.
+*/
+func TestComposeTransforms(t *testing.T) {
+	for _, fixture := range []string{"v2", "v3", "spec", "multi"} {
+		t.Run(fixture, func(t *testing.T) {
+			c := loadFixture(t, fixture)
+			if err := c.Apply(testOptions.Transforms()...); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "compose", fixture+".golden.yaml")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestComposeRoundTrip(t *testing.T) {
+	for _, fixture := range []string{"v2", "v3", "spec", "multi"} {
+		in, err := ioutil.ReadFile(filepath.Join("testdata", "compose", fixture+".yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := ParseCompose(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := c.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Comments, key order & quoting are kept.
+		if string(out) != string(in) {
+			t.Errorf("%s: unmodified file was serialized as:\n%s", fixture, out)
+		}
+	}
+}
+
+func TestComposeImages(t *testing.T) {
+	c, err := ParseCompose([]byte("services:\n  b:\n    image: b:1\n  a:\n    image: a:1\n  c:\n    build: .\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Images(), []string{"a:1", "b:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestComposeValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"no services", "version: '3'\n", "no services are defined"},
+		{"service not a mapping", "services:\n  mp: x\n", "is not a mapping"},
+		{"no image", "services:\n  mp:\n    command: x\n", "has no image"},
+		{"host networking", "services:\n  mp:\n    image: x\n    network_mode: host\n", "host networking"},
+		{"invalid container name", "services:\n  mp:\n    image: x\n    container_name: -mp\n", "invalid container name"},
+		{"invalid restart policy", "services:\n  mp:\n    image: x\n    restart: sometimes\n", "invalid restart policy"},
+		{"valid", "services:\n  mp:\n    image: x\n    restart: on-failure:3\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCompose([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestParseComposeErrors(t *testing.T) {
+	for _, input := range []string{"", "# only a comment\n", "- a\n- b\n", "services: [\n"} {
+		if _, err := ParseCompose([]byte(input)); err == nil {
+			t.Errorf("expected an error parsing %q", input)
+		}
+	}
+}
+
+func TestSetContainerNameCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{"plain", "start --containername localhost", "start --containername rpi01"},
+		{"quoted arguments", `start --msg "a  b" --containername localhost 'c  d'`, `start --msg "a  b" --containername rpi01 'c  d'`},
+		{"quoted value", `start --containername "local host"`, "start --containername rpi01"},
+		{"equals", "start --containername=localhost --x", "start --containername=rpi01 --x"},
+		{"escaped spaces", `start a\ b --containername localhost`, `start a\ b --containername rpi01`},
+		{"no argument", "start --verbose", "start --verbose"},
+		{"flag without a value", "start --containername", "start --containername"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCompose([]byte("services:\n  mp:\n    image: x\n    command: ''\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lookup(c.services()[0].node, "command").Value = tt.command
+			if err := c.Apply(SetContainerName("rpi01")); err != nil {
+				t.Fatal(err)
+			}
+			if got := scalar(lookup(c.services()[0].node, "command")); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetContainerNameService(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"several services", "services:\n  a:\n    image: x\n  b:\n    image: y\n", "none of the 2 services"},
+		{"several AppNeta services", "services:\n  a:\n    image: x\n    command: start --containername a\n  b:\n    image: y\n    command: [start, --containername=b]\n", "services a & b both"},
+		{"invalid command", "services:\n  a:\n    image: x\n    command: start 'a\n  b:\n    image: y\n", "service a has an invalid command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCompose([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Apply(SetContainerName("rpi01"))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"  a   b\tc\n", []string{"a", "b", "c"}},
+		{`'a b' "c \"d\" \$e" f\ g`, []string{"a b", `c "d" $e`, "f g"}},
+		{`"a\b" 'c\d'`, []string{`a\b`, `c\d`}},
+		{`a"b c"d`, []string{"ab cd"}},
+		{`''`, []string{""}},
+	}
+	for _, tt := range tests {
+		words, err := splitShellWords(tt.input)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.input, err)
+		}
+		var got []string
+		for _, w := range words {
+			got = append(got, w.value)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitShellWords(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{`'a`, `"a`, `a "b\"`} {
+		if _, err := splitShellWords(input); err == nil {
+			t.Errorf("expected an error splitting %q", input)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+// RootDockerConfig is root's Docker config file, which is used by the appneta-cmp unit.
+const RootDockerConfig string = "/root/.docker/config.json"
+
+/*
+CredentialStore stores registry credentials where Docker (and Docker Compose) run as root will find
+them. If Helper is set, e.g. `pass` or `secretservice`, the credentials are stored with the
+docker-credential-<Helper> program, and only a reference to the helper is written to ConfigFile.
+Otherwise, they're written to ConfigFile, which is only readable by root.
+*/
+type CredentialStore struct {
+	ConfigFile string
+	Helper     string
+}
+
+// helperCredentials is the JSON payload of a Docker credential helper.
+type helperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// registryHost gets the host of a registry. The registry name is a URL with a path, e.g.
+// host.example.com/path. However, Docker stores the registry name as an FQDN, e.g. host.example.com.
+func registryHost(registry string) string {
+	return strings.Split(registry, "/")[0]
+}
+
+func (s CredentialStore) helperCommand(action string, input []byte) ([]byte, error) {
+	bin := "docker-credential-" + s.Helper
+	cmd := exec.Command(bin, action)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// Credential helpers report errors on stdout.
+		return nil, fmt.Errorf("%s %s: %v\n%s%s", bin, action, err, util.AsString(out), util.AsString(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// readConfig reads the Docker config file. If it does not exist, an empty config is returned.
+func (s CredentialStore) readConfig() (config map[string]interface{}, err error) {
+	config = map[string]interface{}{}
+	if !util.FileExists(s.ConfigFile) {
+		return
+	}
+	b, err := ioutil.ReadFile(s.ConfigFile)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &config)
+	return
+}
+
+/*
+writeConfig writes the Docker config file, ensuring that it & its directory are owned by root &
+only accessible by root, even if they already existed with looser permissions.
+*/
+func (s CredentialStore) writeConfig(config map[string]interface{}) error {
+	b, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.ConfigFile)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err = os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	if err = os.Chown(dir, 0, 0); err != nil {
+		return err
+	}
+	return util.WriteFile(s.ConfigFile, append(b, '\n'), util.FileOptions{Perm: 0600})
+}
+
+func section(config map[string]interface{}, key string) map[string]interface{} {
+	m, ok := config[key].(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{}
+		config[key] = m
+	}
+	return m
+}
+
+/*
+Store stores registry credentials, the same way `docker login` does. Any other contents of the
+config file are left as-is.
+*/
+func (s CredentialStore) Store(auth AuthConfig) error {
+	reg := registryHost(auth.ServerAddress)
+	config, err := s.readConfig()
+	if err != nil {
+		return err
+	}
+
+	auths := section(config, "auths")
+	if s.Helper != "" {
+		input, _ := json.Marshal(helperCredentials{ServerURL: reg, Username: auth.Username, Secret: auth.Password})
+		if _, err = s.helperCommand("store", input); err != nil {
+			return err
+		}
+		section(config, "credHelpers")[reg] = s.Helper
+		// Don't leave a copy of the credentials in the config file.
+		delete(auths, reg)
+	} else {
+		creds := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		auths[reg] = map[string]string{"auth": creds}
+		if helpers, ok := config["credHelpers"].(map[string]interface{}); ok {
+			delete(helpers, reg)
+		}
+	}
+	return s.writeConfig(config)
+}
+
+// Get gets the stored credentials of a registry.
+func (s CredentialStore) Get(registry string) (auth AuthConfig, err error) {
+	reg := registryHost(registry)
+	config, err := s.readConfig()
+	if err != nil {
+		return
+	}
+	auth.ServerAddress = reg
+
+	helper := s.Helper
+	if helpers, ok := config["credHelpers"].(map[string]interface{}); ok {
+		if h, ok := helpers[reg].(string); ok {
+			helper = h
+		}
+	}
+	if helper != "" {
+		out, err := CredentialStore{Helper: helper}.helperCommand("get", []byte(reg))
+		if err != nil {
+			return auth, err
+		}
+		var creds helperCredentials
+		if err = json.Unmarshal(out, &creds); err != nil {
+			return auth, err
+		}
+		auth.Username, auth.Password = creds.Username, creds.Secret
+		return auth, nil
+	}
+
+	auths, _ := config["auths"].(map[string]interface{})
+	entry, _ := auths[reg].(map[string]interface{})
+	encoded, _ := entry["auth"].(string)
+	if encoded == "" {
+		return auth, fmt.Errorf("no credentials are stored for %s in %s", reg, s.ConfigFile)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return auth, err
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return auth, fmt.Errorf("invalid credentials for %s in %s", reg, s.ConfigFile)
+	}
+	auth.Username, auth.Password = parts[0], parts[1]
+	return auth, nil
+}
+
+// Erase removes the stored credentials of a registry.
+func (s CredentialStore) Erase(registry string) error {
+	reg := registryHost(registry)
+	config, err := s.readConfig()
+	if err != nil {
+		return err
+	}
+	if helpers, ok := config["credHelpers"].(map[string]interface{}); ok {
+		if h, ok := helpers[reg].(string); ok {
+			if _, err = (CredentialStore{Helper: h}).helperCommand("erase", []byte(reg)); err != nil {
+				return err
+			}
+			delete(helpers, reg)
+		}
+	}
+	if auths, ok := config["auths"].(map[string]interface{}); ok {
+		delete(auths, reg)
+	}
+	return s.writeConfig(config)
+}
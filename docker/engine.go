@@ -0,0 +1,137 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	g "github.com/stellaraf/rmon-node-setup/globals"
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+// composeProject is the docker-compose project name of the AppNeta container, which is derived
+// from the name of the directory the compose file is in (/etc/docker/compose).
+const composeProject string = "compose"
+
+// Engine creates a Docker Engine API client for the local Docker daemon.
+func Engine() *Client {
+	return NewClient(g.DockerSocket)
+}
+
+// CheckEngine waits for the Docker daemon to respond & logs its version.
+func CheckEngine() (v VersionResponse) {
+	c := Engine()
+	err := c.WaitReady(30 * time.Second)
+	util.Check("Docker daemon is not responding on %s", err, c.Socket)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	v, err = c.Version(ctx)
+	util.Check("Error getting Docker version: ", err)
+
+	util.Info("Docker Engine %s (API %s, %s/%s)", v.Version, v.APIVersion, v.Os, v.Arch)
+	return
+}
+
+// registryLogin verifies registry credentials with the Docker daemon, and if they're valid, stores
+// them in creds.
+func registryLogin(auth AuthConfig, creds CredentialStore) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := Engine().Auth(ctx, auth)
+	util.Check("Error logging in to AppNeta Docker Registry %s", err, auth.ServerAddress)
+	util.Info("Docker registry %s: %s", auth.ServerAddress, res.Status)
+
+	storeRegistryAuth(auth, creds)
+}
+
+// storeRegistryAuth stores registry credentials in creds.
+func storeRegistryAuth(auth AuthConfig, creds CredentialStore) {
+	err := creds.Store(auth)
+	if creds.Helper != "" {
+		util.Check("Error storing Docker registry credentials with docker-credential-%s", err, creds.Helper)
+		util.Info("Stored credentials for %s with docker-credential-%s", auth.ServerAddress, creds.Helper)
+	} else {
+		util.Check("Error saving Docker registry credentials to %s", err, creds.ConfigFile)
+		util.Info("Stored credentials for %s in %s", auth.ServerAddress, creds.ConfigFile)
+	}
+}
+
+/*
+RegistryStatus verifies the stored credentials of a registry by having the Docker daemon
+authenticate to the registry with them. A nil error means the credentials are stored & valid.
+*/
+func RegistryStatus(registry string, creds CredentialStore) error {
+	auth, err := creds.Get(registry)
+	if err != nil {
+		return err
+	}
+	auth.ServerAddress = registry
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err = Engine().Auth(ctx, auth)
+	return err
+}
+
+/*
+composeImages reads the image references from the docker-compose file of an extracted
+configuration bundle. Variables in the form of ${VAR} or $VAR are expanded from the bundle's .env
+file.
+*/
+func composeImages(dir string) (images []string) {
+	filename := filepath.Join(dir, "mp-compose.yaml")
+	vars := readEnv(filepath.Join(dir, ".env")).Vars
+	c, err := LoadCompose(filename)
+	util.Check("Error reading docker-compose file %s", err, filename)
+	for _, image := range c.Images() {
+		images = append(images, os.Expand(image, func(k string) string { return vars[k] }))
+	}
+	return
+}
+
+// pullImages pulls each image with the given registry credentials.
+func pullImages(images []string, auth AuthConfig) {
+	c := Engine()
+	for _, image := range images {
+		util.Info("Pulling image %s...", image)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		err := c.PullImage(ctx, image, &auth, nil)
+		cancel()
+		util.Check("Error pulling image %s", err, image)
+		util.Success("Pulled image %s", image)
+	}
+}
+
+/*
+Verify inspects the AppNeta container(s) started by docker-compose & logs their state. If none are
+running, a warning is logged.
+*/
+func Verify() {
+	c := Engine()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filters := map[string][]string{"label": {"com.docker.compose.project=" + composeProject}}
+	containers, err := c.ListContainers(ctx, filters)
+	util.Check("Error listing Docker containers: ", err)
+
+	running := 0
+	for _, s := range containers {
+		container, err := c.InspectContainer(ctx, s.ID)
+		util.Check("Error inspecting container %s", err, s.ID)
+		name := strings.TrimPrefix(container.Name, "/")
+
+		if container.State.Running {
+			running++
+			util.Success("Container %s (%s) is %s", name, container.Config.Image, container.State.Status)
+		} else {
+			util.Warning("Container %s (%s) is %s", name, container.Config.Image, container.State.Status)
+		}
+	}
+	if running == 0 {
+		util.Warning("No AppNeta containers are running")
+	}
+}
@@ -42,6 +42,12 @@ func CreateGroup(user string) {
 
 // EnableStartup enables docker to start on boot.
 func EnableStartup() {
+	enabled := exec.Command("systemctl", "is-enabled", "--quiet", "docker").Run() == nil
 	out, err := exec.Command("systemctl", "enable", "docker").CombinedOutput()
 	util.Check("Error setting Docker to start on boot:\n%s", err, util.AsString(out))
+	if !enabled {
+		util.OnRollback("Stop Docker from starting on boot", func() error {
+			return exec.Command("systemctl", "disable", "docker").Run()
+		})
+	}
 }
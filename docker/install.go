@@ -1,17 +1,18 @@
 package docker
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 	"os/exec"
 	"path"
-	"regexp"
+	"strings"
 
-	g "github.com/stellaraf/rmon-node-setup/globals"
+	dotenv "github.com/stellaraf/rmon-node-setup/dotenv"
 	util "github.com/stellaraf/rmon-node-setup/util"
 )
 
+// ComposeDir is the directory of the installed AppNeta compose file & .env file.
+const ComposeDir string = "/etc/docker/compose"
+
 func getArch() (arch string) {
 	cmd := exec.Command("dpkg", "--print-architecture")
 	output, err := cmd.Output()
@@ -19,106 +20,180 @@ func getArch() (arch string) {
 	return util.AsString(output)
 }
 
-func getRelease() (osID string, release string) {
-	osPattern := regexp.MustCompile(`^ID=(\w+)$`)
-	releasePattern := regexp.MustCompile(`^VERSION_CODENAME=(\w+)$`)
-	file, err := os.Open("/etc/os-release")
-	util.Check("Error reading OS info from /etc/os-release: ", err)
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	osID = ""
-	release = ""
-	for scanner.Scan() {
-		line := scanner.Text()
-		osMatch := osPattern.FindStringSubmatch(line)
-		relMatch := releasePattern.FindStringSubmatch(line)
-		if len(osMatch) > 1 {
-			osID = osMatch[1]
-		}
-		if len(relMatch) > 1 {
-			release = relMatch[1]
-		}
-	}
-	if osID == "" {
-		util.Critical("No OS was detected")
-		os.Exit(1)
-	}
-	if release == "" {
-		util.Critical("No release was detected")
-		os.Exit(1)
+/*
+aptSetup adds Docker's APT repository, signed by Docker's signing key which is installed into
+DockerKeyring, so that only packages signed by Docker are trusted from it.
+*/
+func aptSetup(osr util.OSRelease) {
+	arch := getArch()
+	if osr.Codename == "" {
+		util.Critical("No release codename was detected for %s", osr.String())
+		util.Exit(1)
 	}
+	util.Info("OS/Release: %s/%s", osr.ID, osr.Codename)
 
-	util.Info("OS/Release: %s/%s", osID, release)
-	return osID, release
-}
+	repoOS, err := dockerRepoOS(osr, arch)
+	util.Check("Error setting up Docker's APT repository", err)
 
-func aptSetup() {
-	arch := getArch()
-	osID, release := getRelease()
+	installDockerKey()
 
-	repoTmpl := "deb [arch=%s] https://download.docker.com/linux/%s %s stable"
-	repo := fmt.Sprintf(repoTmpl, arch, osID, release)
+	repoTmpl := "deb [arch=%s signed-by=%s] https://download.docker.com/linux/%s %s stable"
+	repo := fmt.Sprintf(repoTmpl, arch, DockerKeyring, repoOS, osr.Codename)
 
 	filename := "/etc/apt/sources.list.d/docker.list"
-	file, err := os.Create(filename)
-	util.Check("Error creating docker APT source file at %s: ", err, filename)
-	defer file.Close()
-
-	_, err = file.WriteString("# This file is automatically generated by rmon-node-setup. Do not override.\n" + repo + "\n")
+	content := "# This file is automatically generated by rmon-node-setup. Do not override.\n" + repo + "\n"
+	err = util.WriteFile(filename, []byte(content), util.FileOptions{Perm: 0644})
 	util.Check("Error writing APT repo source to file %s: ", err, filename)
 
 	util.Success("Added %s to APT sources", repo)
 }
 
-func composeInstalled() (i bool) {
-	cmd := exec.Command("python3", "-m", "compose")
-	_, err := cmd.CombinedOutput()
-	if err != nil {
-		i = false
-	} else {
-		i = true
-	}
-	return
+/*
+dnfSetup adds Docker's RPM repository, signed by Docker's signing key which is installed into
+DockerRPMKey.
+*/
+func dnfSetup(osr util.OSRelease) {
+	util.Info("OS/Release: %s/%s", osr.ID, osr.Version)
+
+	repoOS, err := dockerRepoOS(osr, "")
+	util.Check("Error setting up Docker's RPM repository", err)
+
+	installDockerRPMKey()
+
+	repoTmpl := `# This file is automatically generated by rmon-node-setup. Do not override.
+[docker-ce-stable]
+name=Docker CE Stable - $basearch
+baseurl=https://download.docker.com/linux/%s/$releasever/$basearch/stable
+enabled=1
+gpgcheck=1
+gpgkey=file://%s
+`
+	repo := fmt.Sprintf(repoTmpl, repoOS, DockerRPMKey)
+
+	filename := "/etc/yum.repos.d/docker-ce.repo"
+	err = util.WriteFile(filename, []byte(repo), util.FileOptions{Perm: 0644})
+	util.Check("Error writing Docker RPM repo to file %s: ", err, filename)
+
+	util.Success("Added Docker's %s repository to %s", repoOS, filename)
 }
 
-// Install installs docker.
-func Install() {
+// Install installs docker from Docker's repository for osr, if it isn't already installed.
+func Install(osr util.OSRelease) {
 	util.Info("Installing docker...")
-	aptSetup()
-	out, err := exec.Command("apt-get", "update").CombinedOutput()
-	util.Check("Error updating APT:\n%s", err, util.AsString(out))
-
-	install := exec.Command("apt-get", "install", "-y", "docker-ce", "docker-ce-cli", "containerd.io")
-	out, err = install.CombinedOutput()
-	util.Check("Error installing Docker:\n%s", err, util.AsString(out))
-}
-
-// InstallCompose installs docker-compose if it is not already intstalled.
-func InstallCompose() {
-	installed := composeInstalled()
+	if osr.RHEL() {
+		dnfSetup(osr)
+	} else {
+		aptSetup(osr)
+	}
 
-	if !installed {
-		util.Info("Docker Compose is not installed. Installing...")
-		out, err := exec.Command("pip3", "install", "docker-compose").CombinedOutput()
-		util.Check("Error installing Docker Compose:\n%s", err, util.AsString(out))
+	installed, err := util.EnsurePackages("docker-ce", "docker-ce-cli", "containerd.io")
+	util.Check("Error installing Docker: ", err)
+	if len(installed) == 0 {
+		util.Success("Docker is already installed")
+	} else {
+		util.Success("Installed %s", strings.Join(installed, ", "))
 	}
 }
 
-// Scaffold creates a directory in the docker config directory for docker-compose files.
-func Scaffold(hostname string) {
-	dir := "/etc/docker/compose"
-
-	srcDir := path.Join(fmt.Sprintf(g.HomeDir, g.LocalUser), hostname)
+/*
+Scaffold copies the AppNeta docker-compose & .env files from the extracted bundle in srcDir to the
+docker config directory. The docker-compose file is modified per opts & validated before it is
+written. The .env file contains the AppNeta server key, so it is only readable by root.
+*/
+func Scaffold(srcDir string, opts ComposeOptions) {
+	dir := ComposeDir
 
 	cmpFileSrc := path.Join(srcDir, "mp-compose.yaml")
 	cmpFileDst := path.Join(dir, "appneta-cmp.yaml")
 
-	util.CopyFile(cmpFileSrc, cmpFileDst)
-	util.Success("Copied %s to %s", cmpFileSrc, cmpFileDst)
+	cmp, err := LoadCompose(cmpFileSrc)
+	util.Check("Error reading docker-compose file %s", err, cmpFileSrc)
+
+	err = cmp.Apply(opts.Transforms()...)
+	util.Check("Error modifying docker-compose file %s", err, cmpFileSrc)
+
+	err = cmp.Write(cmpFileDst, 0644)
+	util.Check("Error writing docker-compose file %s", err, cmpFileDst)
+	util.Success("Wrote modified %s to %s", cmpFileSrc, cmpFileDst)
 
 	envFileSrc := path.Join(srcDir, ".env")
 	envFileDst := path.Join(dir, ".env")
 
-	util.CopyFile(envFileSrc, envFileDst)
+	util.CopyPrivate(envFileSrc, envFileDst)
 	util.Success("Copied %s to %s", envFileSrc, envFileDst)
+
+	if opts.ContainerName != "" {
+		setEnvValue(envFileDst, "APPNETA_CONTAINER_NAME", opts.ContainerName)
+		util.Info("Set AppNeta container name to %s", opts.ContainerName)
+	}
+}
+
+// InstalledContainerName gets the AppNeta container name of this node, as set by Scaffold.
+func InstalledContainerName() (string, error) {
+	filename := path.Join(ComposeDir, ".env")
+	f, err := dotenv.Load(filename)
+	if err != nil {
+		return "", err
+	}
+	name, _ := f.Get("APPNETA_CONTAINER_NAME")
+	if name == "" {
+		return "", fmt.Errorf("APPNETA_CONTAINER_NAME is not set in %s", filename)
+	}
+	return name, nil
+}
+
+// InstalledContainer gets the UUID & name of the AppNeta container of this node, as set by Scaffold.
+func InstalledContainer() (uuid, name string, err error) {
+	f, err := dotenv.Load(path.Join(ComposeDir, ".env"))
+	if err != nil {
+		return "", "", err
+	}
+	env := parseEnv(f)
+	return env.ContainerUUID, env.ContainerName, nil
+}
+
+// dockerArchs are the architectures Docker provides packages for, by repository.
+var dockerArchs = map[string][]string{
+	"debian":   {"amd64", "arm64", "armhf"},
+	"ubuntu":   {"amd64", "arm64", "armhf"},
+	"raspbian": {"armhf"},
+	"centos":   {"x86_64", "aarch64"},
+	"rhel":     {"x86_64", "aarch64"},
+	"fedora":   {"x86_64", "aarch64"},
+}
+
+/*
+SupportedArch checks that Docker provides packages for this node's architecture in the Docker
+repository for osr. The architecture & the name of the repository are returned.
+*/
+func SupportedArch(osr util.OSRelease) (arch string, repoOS string, err error) {
+	var out []byte
+	if osr.RHEL() {
+		out, err = exec.Command("uname", "-m").Output()
+	} else {
+		out, err = exec.Command("dpkg", "--print-architecture").Output()
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("error getting CPU architecture: %v", err)
+	}
+	arch = util.AsString(out)
+
+	repoOS, err = dockerRepoOS(osr, arch)
+	if err != nil {
+		return
+	}
+	supported := false
+	for _, a := range dockerArchs[repoOS] {
+		if a == arch {
+			supported = true
+		}
+	}
+	if !supported {
+		return arch, repoOS, fmt.Errorf("Docker does not provide %s packages for %s, only %s", arch, repoOS, strings.Join(dockerArchs[repoOS], ", "))
+	}
+	// 32 bit Raspberry Pi OS is armhf even on the ARMv6 Raspberry Pi 1 & Zero, which Docker no longer supports.
+	if m, _ := exec.Command("uname", "-m").Output(); util.AsString(m) == "armv6l" {
+		return arch, repoOS, fmt.Errorf("Docker does not support ARMv6 CPUs, e.g. the Raspberry Pi 1 & Zero")
+	}
+	return arch, repoOS, nil
 }
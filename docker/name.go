@@ -0,0 +1,71 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+/*
+DefaultContainerName is the default template of the AppNeta container name. It's the name the
+configuration bundle is downloaded with, so AppNeta creates the appliance with the same name.
+*/
+const DefaultContainerName string = "{{.HostnameDashes}}"
+
+// maxContainerNameLength is the maximum length of an AppNeta monitoring point name.
+const maxContainerNameLength int = 64
+
+/*
+appNetaNamePattern matches names accepted by both AppNeta (monitoring point names) and Docker
+(container names): letters, numbers, periods, underscores & hyphens, starting with a letter or
+number.
+*/
+var appNetaNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// NameData is the node identity available to the container name template.
+type NameData struct {
+	// NodeID is the 2 digit node ID, e.g. 01.
+	NodeID string
+	// Hostname is the node's FQDN, e.g. rpi01.rmon.orion.cloud.
+	Hostname string
+	// Site is an optional site label, e.g. a customer or location name.
+	Site string
+}
+
+// HostnameDashes gets the hostname with its periods replaced by hyphens, e.g. rpi01-rmon-orion-cloud.
+func (d NameData) HostnameDashes() string {
+	return strings.ReplaceAll(d.Hostname, ".", "-")
+}
+
+// ValidateContainerName checks a container name against AppNeta's & Docker's naming rules.
+func ValidateContainerName(name string) error {
+	if name == "" {
+		return fmt.Errorf("container name is empty")
+	}
+	if len(name) > maxContainerNameLength {
+		return fmt.Errorf("container name '%s' is %d characters long, but must be no more than %d", name, len(name), maxContainerNameLength)
+	}
+	if !appNetaNamePattern.MatchString(name) {
+		return fmt.Errorf("container name '%s' must start with a letter or number, and may only contain letters, numbers, '.', '_' & '-'", name)
+	}
+	return nil
+}
+
+/*
+ContainerName renders the container name template with the node identity, e.g.
+`rmon-{{.NodeID}}-{{.Site}}`, and validates the result.
+*/
+func ContainerName(tmpl string, data NameData) (string, error) {
+	t, err := template.New("container-name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid container name template '%s': %v", tmpl, err)
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid container name template '%s': %v", tmpl, err)
+	}
+	name := strings.TrimSpace(buf.String())
+	return name, ValidateContainerName(name)
+}
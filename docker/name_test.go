@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContainerName(t *testing.T) {
+	data := NameData{NodeID: "01", Hostname: "rpi01.rmon.orion.cloud", Site: "hq"}
+	tests := []struct {
+		tmpl string
+		want string
+		err  string
+	}{
+		{DefaultContainerName, "rpi01-rmon-orion-cloud", ""},
+		{"{{.Hostname}}", "rpi01.rmon.orion.cloud", ""},
+		{"rmon-{{.NodeID}}-{{.Site}}", "rmon-01-hq", ""},
+		{" {{.NodeID}} ", "01", ""},
+		{"{{.Missing}}", "", "invalid container name template"},
+		{"{{.NodeID", "", "invalid container name template"},
+		{"rmon {{.NodeID}}", "rmon 01", "may only contain"},
+		{"-{{.NodeID}}", "-01", "must start with a letter or number"},
+		{"", "", "empty"},
+		{strings.Repeat("x", 65), strings.Repeat("x", 65), "no more than 64"},
+	}
+	for _, tt := range tests {
+		got, err := ContainerName(tt.tmpl, data)
+		if tt.err == "" && err != nil {
+			t.Errorf("ContainerName(%q): %v", tt.tmpl, err)
+			continue
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("ContainerName(%q): expected an error containing %q, got %v", tt.tmpl, tt.err, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ContainerName(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
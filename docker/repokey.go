@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+// DockerKeyring is the keyring of Docker's APT repository signing key, referenced by signed-by.
+const DockerKeyring string = "/etc/apt/keyrings/docker.gpg"
+
+// DockerRPMKey is Docker's RPM repository signing key, referenced by gpgkey.
+const DockerRPMKey string = "/etc/pki/rpm-gpg/RPM-GPG-KEY-docker-ce"
+
+//go:generate go run genkeys.go
+
+// dockerKeyFingerprint is the pinned fingerprint of Docker's APT repository signing key.
+const dockerKeyFingerprint string = "9DC858229FC7DD38854AE2D88D81803C0EBFCD88"
+
+// dockerRPMKeyFingerprint is the pinned fingerprint of Docker's RPM repository signing key.
+const dockerRPMKeyFingerprint string = "060A61C51B558A7F742B77AAC52FEB6B621E9F35"
+
+/*
+dockerRepoOS gets the name of the Docker repository for an OS. Raspberry Pi OS identifies itself as
+raspbian; its 32 bit release uses Docker's raspbian repository, but its 64 bit release is plain
+Debian as far as Docker is concerned. Other Debian or Ubuntu derivatives use the repository of the OS
+in ID_LIKE. RHEL derivatives such as Rocky Linux & AlmaLinux use the centos repository.
+*/
+func dockerRepoOS(osr util.OSRelease, arch string) (string, error) {
+	switch osr.ID {
+	case "debian", "ubuntu", "fedora", "rhel", "centos":
+		return osr.ID, nil
+	case "raspbian":
+		if arch == "armhf" {
+			return "raspbian", nil
+		}
+		return "debian", nil
+	}
+	for _, like := range osr.IDLike {
+		if like == "ubuntu" || like == "debian" {
+			return like, nil
+		}
+	}
+	if osr.RHEL() {
+		return "centos", nil
+	}
+	return "", &util.UnsupportedOSError{OS: osr}
+}
+
+// gpg runs gpg with an isolated home directory, so that root's keyring is left as-is.
+func gpg(input []byte, args ...string) ([]byte, error) {
+	home := util.PrivateTempDir("rmon-gnupg-")
+	cmd := exec.Command("gpg", append([]string{"--batch", "--no-tty", "--homedir", home}, args...)...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return out, nil
+}
+
+/*
+verifyKeyFingerprint checks that key contains exactly one primary key, and that its fingerprint is
+fingerprint.
+*/
+func verifyKeyFingerprint(key []byte, fingerprint string) error {
+	out, err := gpg(key, "--with-colons", "--import-options", "show-only", "--import")
+	if err != nil {
+		return err
+	}
+	var primaries []string
+	primary := false
+	for _, line := range strings.Split(util.AsString(out), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub":
+			primary = true
+		case fields[0] == "fpr" && primary && len(fields) > 9:
+			primaries = append(primaries, fields[9])
+			primary = false
+		}
+	}
+	if len(primaries) != 1 {
+		return fmt.Errorf("expected 1 key, got %d", len(primaries))
+	}
+	if !strings.EqualFold(primaries[0], fingerprint) {
+		return fmt.Errorf("key fingerprint %s does not match %s", primaries[0], fingerprint)
+	}
+	return nil
+}
+
+/*
+verifiedDockerKey gets an embedded signing key of a Docker repository, after verifying its
+fingerprint. The keys are embedded, rather than downloaded, so that a compromised or unreachable
+download.docker.com can't affect which key is trusted.
+*/
+func verifiedDockerKey(armored string, fingerprint string) []byte {
+	if armored == "" {
+		util.Critical("Docker's signing key %s isn't embedded in this build. Run 'go generate ./docker' & rebuild.", fingerprint)
+		util.Exit(1)
+	}
+	key := []byte(armored)
+	err := verifyKeyFingerprint(key, fingerprint)
+	util.Check("Docker's signing key could not be verified", err)
+	return key
+}
+
+// writeKey replaces a key file via WriteFile, so that the package manager never sees a partial key.
+func writeKey(filename string, key []byte) {
+	dir := filepath.Dir(filename)
+	err := os.MkdirAll(dir, 0755)
+	util.Check("Error creating %s", err, dir)
+
+	err = util.WriteFile(filename, key, util.FileOptions{Perm: 0644})
+	util.Check("Error writing %s", err, filename)
+}
+
+/*
+installDockerKey installs the embedded signing key of Docker's APT repositories into DockerKeyring,
+after verifying it against the pinned fingerprint.
+*/
+func installDockerKey() {
+	key := verifiedDockerKey(dockerAPTKey, dockerKeyFingerprint)
+
+	keyring, err := gpg(key, "--dearmor")
+	util.Check("Error converting Docker's APT signing key", err)
+	writeKey(DockerKeyring, keyring)
+
+	util.Success("Installed Docker's APT signing key %s to %s", dockerKeyFingerprint, DockerKeyring)
+}
+
+/*
+installDockerRPMKey installs the embedded signing key of Docker's RPM repositories into DockerRPMKey
+& the RPM database, after verifying it against the pinned fingerprint.
+*/
+func installDockerRPMKey() {
+	key := verifiedDockerKey(dockerRPMKey, dockerRPMKeyFingerprint)
+	writeKey(DockerRPMKey, key)
+
+	out, err := exec.Command("rpm", "--import", DockerRPMKey).CombinedOutput()
+	util.Check("Error importing Docker's RPM signing key:\n%s", err, util.AsString(out))
+
+	util.Success("Installed Docker's RPM signing key %s to %s", dockerRPMKeyFingerprint, DockerRPMKey)
+}
@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+// testKey generates an armored signing key & gets its fingerprint.
+func testKey(t *testing.T) ([]byte, string) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	home, err := ioutil.TempDir("", "repokey-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	gpg := func(args ...string) []byte {
+		out, err := exec.Command("gpg", append([]string{"--batch", "--no-tty", "--homedir", home}, args...)...).Output()
+		if err != nil {
+			t.Fatalf("gpg %s: %v", strings.Join(args, " "), err)
+		}
+		return out
+	}
+	gpg("--passphrase", "", "--quick-gen-key", "rmon-node-setup test <test@example.com>", "ed25519", "sign", "never")
+	key := gpg("--armor", "--export")
+	for _, line := range strings.Split(string(gpg("--with-colons", "--list-keys")), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+			return key, fields[9]
+		}
+	}
+	t.Fatal("no fingerprint was listed")
+	return nil, ""
+}
+
+func TestVerifyKeyFingerprint(t *testing.T) {
+	key, fingerprint := testKey(t)
+	if err := verifyKeyFingerprint(key, fingerprint); err != nil {
+		t.Errorf("expected %s to be verified: %v", fingerprint, err)
+	}
+	if err := verifyKeyFingerprint(key, strings.ToLower(fingerprint)); err != nil {
+		t.Errorf("expected a lower case fingerprint to be verified: %v", err)
+	}
+	if err := verifyKeyFingerprint(key, dockerKeyFingerprint); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected a mismatched fingerprint error, got %v", err)
+	}
+	if err := verifyKeyFingerprint(append(key, key...), fingerprint); err == nil {
+		t.Error("expected an error from a key which isn't armored once")
+	}
+}
+
+// TestEmbeddedDockerKeys fails if the keys haven't been generated, so a build without them can't pass.
+func TestEmbeddedDockerKeys(t *testing.T) {
+	keys := []struct {
+		name, armored, fingerprint string
+	}{
+		{"APT", dockerAPTKey, dockerKeyFingerprint},
+		{"RPM", dockerRPMKey, dockerRPMKeyFingerprint},
+	}
+	_, gpgErr := exec.LookPath("gpg")
+	for _, k := range keys {
+		if k.armored == "" {
+			t.Errorf("Docker's %s signing key isn't embedded, run 'go generate ./docker'", k.name)
+			continue
+		}
+		if gpgErr != nil {
+			t.Logf("gpg is not installed, not checking the fingerprint of the embedded %s key", k.name)
+			continue
+		}
+		if err := verifyKeyFingerprint([]byte(k.armored), k.fingerprint); err != nil {
+			t.Errorf("embedded %s key: %v", k.name, err)
+		}
+	}
+}
+
+func TestDockerRepoOS(t *testing.T) {
+	tests := []struct {
+		fixture string
+		arch    string
+		want    string
+	}{
+		{"ubuntu-22.04", "amd64", "ubuntu"},
+		{"debian-12", "arm64", "debian"},
+		{"raspbian-11", "armhf", "raspbian"},
+		{"raspbian-11", "arm64", "debian"},
+		{"centos-stream-9", "", "centos"},
+		{"rhel-9.3", "", "rhel"},
+		{"rocky-9.3", "", "centos"},
+		{"almalinux-9.3", "", "centos"},
+		{"alpine-3.19", "amd64", ""},
+	}
+	for _, tt := range tests {
+		b, err := ioutil.ReadFile(filepath.Join("..", "util", "testdata", "os-release", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		osr, err := util.ParseOSRelease(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := dockerRepoOS(osr, tt.arch)
+		if tt.want == "" {
+			if _, ok := err.(*util.UnsupportedOSError); !ok {
+				t.Errorf("%s: expected an UnsupportedOSError, got %q, %v", tt.fixture, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.fixture, err)
+		} else if got != tt.want {
+			t.Errorf("%s on %s: got repository %s, want %s", tt.fixture, tt.arch, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Package dotenv parses & serializes .env files, such as the one in the AppNeta configuration bundle.
+
+Lines are in the form of KEY=value, optionally prefixed with `export`. Values may be unquoted,
+single-quoted (literal) or double-quoted (with \n, \r, \t, \" & \\ escapes). Blank lines, comments
+& the original formatting of unmodified lines are preserved when a file is serialized.
+
+This package doesn't write files itself, since util depends on it to parse os-release. Files are
+written with util.WriteFile.
+*/
+package dotenv
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+var safeValuePattern = regexp.MustCompile(`^[A-Za-z0-9_./:,@%+=-]*$`)
+
+// line is a single line of a .env file. If key is empty, the line is blank or a comment.
+type line struct {
+	raw    string
+	key    string
+	value  string
+	export bool
+	// eol is the line ending: \n, \r\n, or empty for a last line without one.
+	eol string
+}
+
+// File is a parsed .env file.
+type File struct {
+	lines []line
+	// eol is the line ending of new lines, which is \r\n if the parsed input used it.
+	eol string
+}
+
+// ParseError is returned for a line which can't be parsed.
+type ParseError struct {
+	Line    int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+/*
+Parse parses the contents of a .env file. The line ending of each line, including whether the last
+line has one, is kept, so that an unmodified file is serialized byte for byte.
+*/
+func Parse(b []byte) (*File, error) {
+	f := &File{eol: "\n"}
+	text := string(b)
+	if strings.Contains(text, "\r\n") {
+		f.eol = "\r\n"
+	}
+	for i := 0; text != ""; i++ {
+		raw, eol := text, ""
+		if n := strings.IndexByte(text, '\n'); n >= 0 {
+			raw, eol, text = text[:n], "\n", text[n+1:]
+		} else {
+			text = ""
+		}
+		if strings.HasSuffix(raw, "\r") && eol != "" {
+			raw, eol = raw[:len(raw)-1], "\r\n"
+		}
+		l, err := parseLine(raw)
+		if err != nil {
+			return nil, &ParseError{Line: i + 1, Message: err.Error()}
+		}
+		l.eol = eol
+		f.lines = append(f.lines, l)
+	}
+	return f, nil
+}
+
+func parseLine(raw string) (l line, err error) {
+	l.raw = raw
+	s := strings.TrimSpace(raw)
+	if s == "" || strings.HasPrefix(s, "#") {
+		return l, nil
+	}
+	if strings.HasPrefix(s, "export ") || strings.HasPrefix(s, "export\t") {
+		s = strings.TrimSpace(s[len("export"):])
+		l.export = true
+	}
+	eq := strings.Index(s, "=")
+	if eq < 0 {
+		return l, fmt.Errorf("expected KEY=value, got %q", raw)
+	}
+	key := strings.TrimSpace(s[:eq])
+	if !keyPattern.MatchString(key) {
+		return l, fmt.Errorf("invalid key %q", key)
+	}
+	l.key = key
+	l.value, err = parseValue(strings.TrimLeft(s[eq+1:], " \t"))
+	return l, err
+}
+
+func parseValue(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single-quoted value")
+		}
+		return s[1 : end+1], trailing(s[end+2:])
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			c := s[i]
+			if c == '"' {
+				return b.String(), trailing(s[i+1:])
+			}
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(s[i])
+				}
+				continue
+			}
+			b.WriteByte(c)
+		}
+		return "", fmt.Errorf("unterminated double-quoted value")
+	}
+	// An unquoted value ends at an inline comment, which must be preceded by whitespace.
+	for i := 1; i < len(s); i++ {
+		if s[i] == '#' && (s[i-1] == ' ' || s[i-1] == '\t') {
+			s = s[:i]
+			break
+		}
+	}
+	return strings.TrimRight(s, " \t"), nil
+}
+
+// trailing checks that only whitespace or a comment follows a quoted value.
+func trailing(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "#") {
+		return fmt.Errorf("unexpected %q after quoted value", s)
+	}
+	return nil
+}
+
+// quote formats a value so that it parses back to the same value.
+func quote(value string) string {
+	if safeValuePattern.MatchString(value) {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
+
+func newLine(key, value string, export bool, eol string) line {
+	raw := key + "=" + quote(value)
+	if export {
+		raw = "export " + raw
+	}
+	return line{raw: raw, key: key, value: value, export: export, eol: eol}
+}
+
+// Load reads & parses a .env file.
+func Load(filename string) (*File, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Get gets the value of key. If key is defined more than once, the last value is used.
+func (f *File) Get(key string) (value string, ok bool) {
+	for _, l := range f.lines {
+		if l.key == key {
+			value, ok = l.value, true
+		}
+	}
+	return
+}
+
+/*
+Set sets the value of key. Every existing line defining key is replaced, and if there are none, a
+new line is added to the end of the file.
+*/
+func (f *File) Set(key, value string) error {
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	found := false
+	for i := range f.lines {
+		if f.lines[i].key == key {
+			f.lines[i] = newLine(key, value, f.lines[i].export, f.lines[i].eol)
+			found = true
+		}
+	}
+	if !found {
+		eol := f.eol
+		if eol == "" {
+			eol = "\n"
+		}
+		// The last line needs a line ending before a line can follow it.
+		if n := len(f.lines); n > 0 && f.lines[n-1].eol == "" {
+			f.lines[n-1].eol = eol
+		}
+		f.lines = append(f.lines, newLine(key, value, false, eol))
+	}
+	return nil
+}
+
+// Delete removes every line defining key.
+func (f *File) Delete(key string) {
+	lines := f.lines[:0]
+	for _, l := range f.lines {
+		if l.key != key {
+			lines = append(lines, l)
+		}
+	}
+	f.lines = lines
+}
+
+// Keys gets each defined key, in the order they first appear.
+func (f *File) Keys() (keys []string) {
+	seen := map[string]bool{}
+	for _, l := range f.lines {
+		if l.key != "" && !seen[l.key] {
+			seen[l.key] = true
+			keys = append(keys, l.key)
+		}
+	}
+	return
+}
+
+// Map gets every key & value.
+func (f *File) Map() map[string]string {
+	m := map[string]string{}
+	for _, l := range f.lines {
+		if l.key != "" {
+			m[l.key] = l.value
+		}
+	}
+	return m
+}
+
+/*
+Bytes serializes the file, with the same line endings as the parsed input. Write it with
+util.WriteFile, so that it's replaced atomically & backed up like every other managed file.
+*/
+func (f *File) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, l := range f.lines {
+		buf.WriteString(l.raw)
+		buf.WriteString(l.eol)
+	}
+	return buf.Bytes()
+}
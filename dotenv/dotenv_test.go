@@ -0,0 +1,150 @@
+package dotenv
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{"unquoted", "A=1\nB=two words\n", map[string]string{"A": "1", "B": "two words"}},
+		{"empty value", "A=\n", map[string]string{"A": ""}},
+		{"whitespace around the value", "A =  1  \n", map[string]string{"A": "1"}},
+		{"single quoted", `A='literal \n "x" # not a comment'` + "\n", map[string]string{"A": `literal \n "x" # not a comment`}},
+		{"double quoted", `A="line\nnext \"q\" \\ \t"` + "\n", map[string]string{"A": "line\nnext \"q\" \\ \t"}},
+		{"double quoted with a comment", `A="x # y" # comment` + "\n", map[string]string{"A": "x # y"}},
+		{"export", "export A=1\nexport\tB='2'\n", map[string]string{"A": "1", "B": "2"}},
+		{"inline comment", "A=1 # comment\nB=2\t# comment\n", map[string]string{"A": "1", "B": "2"}},
+		{"hash without whitespace", "A=pass#word\n", map[string]string{"A": "pass#word"}},
+		{"comments & blank lines", "# header\n\n  # indented\nA=1\n\n", map[string]string{"A": "1"}},
+		{"CRLF", "A=1\r\nB=\"2\"\r\n", map[string]string{"A": "1", "B": "2"}},
+		{"no final newline", "A=1\nB=2", map[string]string{"A": "1", "B": "2"}},
+		{"last definition wins", "A=1\nA=2\n", map[string]string{"A": "2"}},
+		{"AppNeta", "APPNETA_SERVER_ADDRESS=app-14.pm.appneta.com\nAPPNETA_SERVER_PORTS=80,8080\nAPPNETA_CONTAINER_UUID=0C5D62FF-3EB3-46B1-A2D2-0707BE8A2820\n",
+			map[string]string{"APPNETA_SERVER_ADDRESS": "app-14.pm.appneta.com", "APPNETA_SERVER_PORTS": "80,8080", "APPNETA_CONTAINER_UUID": "0C5D62FF-3EB3-46B1-A2D2-0707BE8A2820"}},
+		{"empty", "", map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Map(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if got := f.Bytes(); string(got) != tt.input {
+				t.Errorf("unmodified file serialized as %q, want %q", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{"missing =", "A=1\nB\n", 2},
+		{"invalid key", "1A=1\n", 1},
+		{"unterminated single quote", "A='x\n", 1},
+		{"unterminated double quote", "A=\"x\n", 1},
+		{"text after a quoted value", "A=\"x\" y\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.input))
+			parseErr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("expected a ParseError, got %v", err)
+			}
+			if parseErr.Line != tt.line {
+				t.Errorf("error on line %d, want %d", parseErr.Line, tt.line)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		key   string
+		value string
+		want  string
+	}{
+		{"replace", "# c\nA=1 # old\nB=2\n", "A", "3", "# c\nA=3\nB=2\n"},
+		{"replace export", "export A=1\n", "A", "2", "export A=2\n"},
+		{"append", "A=1\n", "B", "2", "A=1\nB=2\n"},
+		{"append after no final newline", "A=1", "B", "2", "A=1\nB=2\n"},
+		{"append with CRLF", "A=1\r\n", "B", "2", "A=1\r\nB=2\r\n"},
+		{"quoted value", "A=1\n", "A", "two words", "A=\"two words\"\n"},
+		{"escaped value", "", "A", "x\n\"y\"", "A=\"x\\n\\\"y\\\"\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Set(tt.key, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(f.Bytes()); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if v, _ := f.Get(tt.key); v != tt.value {
+				t.Errorf("Get(%s) = %q, want %q", tt.key, v, tt.value)
+			}
+		})
+	}
+}
+
+// roundTrip checks that an input which parses is serialized byte for byte, and that a value set in
+// it parses back to the same value.
+func roundTrip(t *testing.T, input []byte, value string) {
+	f, err := Parse(input)
+	if err != nil {
+		return
+	}
+	out := f.Bytes()
+	if !bytes.Equal(out, input) {
+		t.Fatalf("%q was serialized as %q", input, out)
+	}
+	reparsed, err := Parse(out)
+	if err != nil {
+		t.Fatalf("serialized %q doesn't parse: %v", out, err)
+	}
+	if !reflect.DeepEqual(reparsed.Map(), f.Map()) {
+		t.Fatalf("%q parsed as %v, then %v", input, f.Map(), reparsed.Map())
+	}
+
+	if err := f.Set("ROUND_TRIP", value); err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err = Parse(f.Bytes())
+	if err != nil {
+		t.Fatalf("%q doesn't parse after setting %q: %v", f.Bytes(), value, err)
+	}
+	if got, _ := reparsed.Get("ROUND_TRIP"); got != value {
+		t.Fatalf("set %q, but got %q from %q", value, got, f.Bytes())
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		"A=1\n", "A=1", "A=1\r\nB=2", "A=1\r\nB=2\n", "# c\n\nexport A='x' # y\n", "\n\n", "A=\"\\q\"\n",
+	}
+	values := []string{"", "plain", "two words", "x\ny", `"quoted"`, `back\slash`, "#", "tab\t", "cr\r"}
+	for _, input := range inputs {
+		for _, value := range values {
+			roundTrip(t, []byte(input), value)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build go1.18
+// +build go1.18
+
+// Fuzz tests need Go 1.18, so they're kept out of the tests of older toolchains.
+
+package dotenv
+
+import "testing"
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte("A=1\nexport B='2' # c\r\nC=\"x\\ny\""), "value")
+	f.Add([]byte(""), "two words")
+	f.Add([]byte("# comment\n\n"), "x\n\"y\"")
+	f.Fuzz(roundTrip)
+}
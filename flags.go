@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	appneta "github.com/stellaraf/rmon-node-setup/appneta"
+	docker "github.com/stellaraf/rmon-node-setup/docker"
+	util "github.com/stellaraf/rmon-node-setup/util"
+	webhook "github.com/stellaraf/rmon-node-setup/webhook"
+)
+
+// APIKeyEnv is the environment variable the AppNeta API Key may be read from.
+const APIKeyEnv string = "RMON_APPNETA_API_KEY"
+
+// appNetaFlags are the flags shared by commands which use the AppNeta API.
+type appNetaFlags struct {
+	url           *string
+	org           *string
+	timeout       *time.Duration
+	retries       *int
+	deadline      *time.Duration
+	apiKeyFile    *string
+	apiKeyCommand *string
+}
+
+func addAppNetaFlags(flags *flag.FlagSet) appNetaFlags {
+	return appNetaFlags{
+		url:           flags.String("appneta-url", appneta.DefaultBaseURL, "Base URL of the AppNeta portal"),
+		org:           flags.String("appneta-org", appneta.DefaultOrgID, "AppNeta organization ID"),
+		timeout:       flags.Duration("appneta-timeout", appneta.DefaultTimeout, "Timeout of each AppNeta API request"),
+		retries:       flags.Int("appneta-retries", appneta.DefaultRetries, "Number of times a failed AppNeta API request is retried"),
+		deadline:      flags.Duration("appneta-deadline", appneta.DefaultDeadline, "Limit of the total time spent on an AppNeta API request, including retries"),
+		apiKeyFile:    flags.String("api-key-file", "", "Path of a file containing the AppNeta API Key"),
+		apiKeyCommand: flags.String("api-key-command", "", "Command which prints the AppNeta API Key, e.g. a password manager CLI"),
+	}
+}
+
+/*
+apiKey gets the AppNeta API Key from --api-key-file, --api-key-command or the RMON_APPNETA_API_KEY
+environment variable, in that order. If none are set, the user is prompted for it.
+*/
+func (f appNetaFlags) apiKey() util.Secret {
+	var (
+		apiKey util.Secret
+		source string
+	)
+	switch {
+	case *f.apiKeyFile != "":
+		source = *f.apiKeyFile
+		info, err := os.Stat(source)
+		util.Check("Error reading AppNeta API Key file %s", err, source)
+		if info.Mode().Perm()&0077 != 0 {
+			util.Warning("AppNeta API Key file %s is accessible by other users, it should be mode 0600", source)
+		}
+		b, err := ioutil.ReadFile(source)
+		util.Check("Error reading AppNeta API Key file %s", err, source)
+		apiKey = util.Secret(strings.TrimSpace(string(b)))
+	case *f.apiKeyCommand != "":
+		source = "command '" + *f.apiKeyCommand + "'"
+		cmd := exec.Command("/bin/sh", "-c", *f.apiKeyCommand)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		util.Check("Error running AppNeta API Key command '%s'", err, *f.apiKeyCommand)
+		apiKey = util.Secret(strings.TrimSpace(string(out)))
+	case os.Getenv(APIKeyEnv) != "":
+		source = "environment variable " + APIKeyEnv
+		apiKey = util.Secret(strings.TrimSpace(os.Getenv(APIKeyEnv)))
+	default:
+		return GetAPIKey()
+	}
+
+	if err := validateAPIKey(apiKey); err != nil {
+		util.Critical("%s from %s", err.Error(), source)
+		util.Exit(1)
+	}
+	util.Info("Using AppNeta API Key from %s", source)
+	return apiKey
+}
+
+/*
+client creates an AppNeta API client which reports its progress & retries to the console. The API
+Key is read or prompted for by apiKey.
+*/
+func (f appNetaFlags) client() *appneta.Client {
+	c := appneta.NewClient(*f.url, *f.org, f.apiKey().Reveal())
+	c.Timeout = *f.timeout
+	c.Retries = *f.retries
+	c.Deadline = *f.deadline
+	c.Progress = util.Progress("Downloading AppNeta configuration")
+	c.OnRetry = func(attempt int, wait time.Duration, err error) {
+		util.Warning("AppNeta request failed (attempt %s of %s), retrying in %s:\n%s", strconv.Itoa(attempt), strconv.Itoa(*f.retries+1), wait.Round(time.Second).String(), err.Error())
+	}
+	return c
+}
+
+// containerNameFlags are the flags which name a node's AppNeta container & appliance.
+type containerNameFlags struct {
+	tmpl *string
+	site *string
+}
+
+func addContainerNameFlags(flags *flag.FlagSet) containerNameFlags {
+	return containerNameFlags{
+		tmpl: flags.String("container-name", docker.DefaultContainerName, "Template of the AppNeta container name. Available fields: {{.NodeID}}, {{.Hostname}}, {{.HostnameDashes}}, {{.Site}}"),
+		site: flags.String("site", "", "Site label of this node, available to --container-name as {{.Site}}"),
+	}
+}
+
+/*
+render gets the AppNeta container name of a node. AppNeta names the appliance after the name its
+configuration is downloaded with, so this one name is used for the download, the container & every
+appliance lookup.
+*/
+func (f containerNameFlags) render(nodeID string) string {
+	name, err := docker.ContainerName(*f.tmpl, docker.NameData{NodeID: nodeID, Hostname: nodeHostname(nodeID), Site: *f.site})
+	util.Check("Invalid AppNeta container name: ", err)
+	return name
+}
+
+// logFlags are the logging flags shared by every command.
+type logFlags struct {
+	level   *string
+	format  *string
+	quiet   *bool
+	verbose *bool
+}
+
+func addLogFlags(flags *flag.FlagSet) logFlags {
+	return logFlags{
+		level:   flags.String("log-level", "info", "Least severe level of messages to print: debug, info, warn or error"),
+		format:  flags.String("log-format", util.LogFormatText, "Format of console messages: text or json"),
+		quiet:   flags.Bool("quiet", false, "Only print warnings & errors, same as --log-level warn"),
+		verbose: flags.Bool("verbose", false, "Print debug messages, same as --log-level debug"),
+	}
+}
+
+/*
+apply configures logging per the flags, and appends this run to util.LogFile. Commands which aren't
+run as root can't write to it, so they're only logged to the console.
+*/
+func (f logFlags) apply() {
+	if err := util.SetLogFormat(*f.format); err != nil {
+		util.Critical(err.Error())
+		util.Exit(1)
+	}
+	level, err := util.ParseLevel(*f.level)
+	if err != nil {
+		util.Critical(err.Error())
+		util.Exit(1)
+	}
+	switch {
+	case *f.quiet && *f.verbose:
+		util.Critical("--quiet & --verbose can't be used together")
+		util.Exit(1)
+	case *f.quiet:
+		level = util.LevelWarn
+	case *f.verbose:
+		level = util.LevelDebug
+	}
+	util.SetLogLevel(level)
+
+	if err := util.OpenLogFile(util.LogFile); err != nil {
+		util.Debug("Not logging to %s: %s", util.LogFile, err.Error())
+	}
+}
+
+// WebhookEnv is the environment variable the webhook URL may be read from.
+const WebhookEnv string = "RMON_WEBHOOK_URL"
+
+// webhookFlags are the flags of the webhook notified when an install succeeds or fails.
+type webhookFlags struct {
+	url     *string
+	format  *string
+	timeout *time.Duration
+	retries *int
+}
+
+func addWebhookFlags(flags *flag.FlagSet) webhookFlags {
+	return webhookFlags{
+		url:     flags.String("webhook", "", "URL of a webhook to notify when the install succeeds or fails (default $"+WebhookEnv+")"),
+		format:  flags.String("webhook-format", webhook.FormatJSON, "Payload of the webhook: json (the install report), slack or teams"),
+		timeout: flags.Duration("webhook-timeout", webhook.DefaultTimeout, "Timeout of each attempt to notify the webhook"),
+		retries: flags.Int("webhook-retries", webhook.DefaultRetries, "Number of times a failed webhook notification is retried"),
+	}
+}
+
+// validate checks the webhook flags, so that a typo is caught before setup makes any changes.
+func (f webhookFlags) validate() error {
+	if !webhook.ValidFormat(*f.format) {
+		return fmt.Errorf("invalid webhook format '%s': must be one of json, slack, teams", *f.format)
+	}
+	if u := f.webhookURL(); u != "" {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			// The URL isn't included, since it may contain a secret.
+			return fmt.Errorf("invalid webhook URL: must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// webhookURL gets the URL of the webhook from --webhook or the RMON_WEBHOOK_URL environment variable.
+func (f webhookFlags) webhookURL() string {
+	if *f.url != "" {
+		return *f.url
+	}
+	return os.Getenv(WebhookEnv)
+}
+
+// client creates a webhook client which reports retries to the console, or nil if no webhook is set.
+func (f webhookFlags) client() *webhook.Client {
+	u := f.webhookURL()
+	if u == "" {
+		return nil
+	}
+	c := webhook.NewClient(u, *f.format)
+	c.Timeout = *f.timeout
+	c.Retries = *f.retries
+	c.OnRetry = func(attempt int, wait time.Duration, err error) {
+		util.Warning("Webhook notification failed (attempt %s of %s), retrying in %s:\n%s", strconv.Itoa(attempt), strconv.Itoa(*f.retries+1), wait.Round(time.Second).String(), err.Error())
+	}
+	return c
+}
@@ -1,13 +1,39 @@
 package constants
 
-// LocalUser is the local user, used for writing files & determining privilege level.
-const LocalUser string = "stellaraf"
+import "fmt"
+
+// DefaultLocalUser is the default local user.
+const DefaultLocalUser string = "stellaraf"
+
+// LocalUser is the local user, used for writing files & determining privilege level. It is set by
+// the --user flag of the install command.
+var LocalUser string = DefaultLocalUser
+
+// LocalHome is the home directory of the LocalUser, as set in its passwd entry. If it is empty,
+// HomeDir is used.
+var LocalHome string
 
 // HomeDir is the unformatted path of the LocalUser's home directory.
 const HomeDir string = "/home/%s"
 
-// SystemdDir is the unformatted path to the local user's systemd service directory.
-const SystemdDir string = HomeDir + "/.config/systemd/user"
+// SystemdDir is the path of the local user's systemd service directory, relative to its home directory.
+const SystemdDir string = ".config/systemd/user"
 
 // HostnameBase is the Base FQDN of the hostname.
 const HostnameBase string = "rmon.orion.cloud"
+
+// DockerSocket is the path to the Docker daemon's unix socket.
+const DockerSocket string = "/var/run/docker.sock"
+
+// UserHome gets the home directory of the LocalUser.
+func UserHome() string {
+	if LocalHome != "" {
+		return LocalHome
+	}
+	return fmt.Sprintf(HomeDir, LocalUser)
+}
+
+// UserSystemdDir gets the path of the LocalUser's systemd service directory.
+func UserSystemdDir() string {
+	return UserHome() + "/" + SystemdDir
+}
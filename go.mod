@@ -4,5 +4,6 @@ go 1.13
 
 require (
 	github.com/fatih/color v1.10.0
-	github.com/tidwall/gjson v1.6.4
+	golang.org/x/sys v0.0.0-20200223170610-d5e6a3e2c0ae
+	gopkg.in/yaml.v3 v3.0.1
 )
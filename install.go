@@ -0,0 +1,201 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	docker "github.com/stellaraf/rmon-node-setup/docker"
+	g "github.com/stellaraf/rmon-node-setup/globals"
+	systemd "github.com/stellaraf/rmon-node-setup/systemd"
+	util "github.com/stellaraf/rmon-node-setup/util"
+
+	color "github.com/fatih/color"
+)
+
+/*
+install sets up this node: the hostname, dependencies, Docker, the AppNeta container & the reverse
+SSH tunnel.
+*/
+func install(args []string) {
+	flags := flag.NewFlagSet("install", flag.ExitOnError)
+	composeMode := flags.String("compose", docker.ComposeAuto, "Docker Compose version to use: auto (prefer the v2 plugin), v1 or v2")
+	restart := flags.String("restart", "unless-stopped", "Restart policy of the AppNeta container")
+	logMaxSize := flags.String("log-max-size", "10m", "Maximum size of the AppNeta container's log file before it is rotated")
+	logMaxFile := flags.Int("log-max-file", 3, "Number of rotated AppNeta container log files to keep")
+	cpus := flags.String("cpus", "", "CPU limit of the AppNeta container, e.g. 1.5 (default unlimited)")
+	memory := flags.String("memory", "", "Memory limit of the AppNeta container, e.g. 512M (default unlimited)")
+	nameOpts := addContainerNameFlags(flags)
+	bundlePath := flags.String("appneta-bundle", "", "Path to a pre-downloaded AppNeta configuration bundle (.tar.gz) to use instead of downloading it")
+	imagesPath := flags.String("images", "", "Path to a tarball of pre-saved images to load instead of pulling them")
+	credentialHelper := flags.String("credential-helper", "", "Docker credential helper used to store the AppNeta registry credentials, e.g. pass for docker-credential-pass (default: "+docker.RootDockerConfig+")")
+	sudoPolicy := flags.String("sudo", util.SudoFull, "Sudo policy of the local user: full, commands (only managing the AppNeta & Docker services, without a password) or none")
+	rollbackOnFailure := flags.Bool("rollback-on-failure", false, "If setup fails, reverse the steps which completed")
+	reportPath := flags.String("report", ReportFile, "Path of the JSON report of the install, which is written even if it fails")
+	reportStdout := flags.Bool("report-stdout", false, "Also print the JSON report to stdout, and everything else to stderr")
+	localUser := flags.String("user", g.DefaultLocalUser, "Local user which runs the reverse SSH tunnel, created if it doesn't exist")
+	appNetaOpts := addAppNetaFlags(flags)
+	webhookOpts := addWebhookFlags(flags)
+	logOpts := addLogFlags(flags)
+	flags.Parse(args)
+	if *reportStdout {
+		util.SetConsole(os.Stderr)
+	}
+	logOpts.apply()
+	started := time.Now()
+	status := 1
+
+	// The report is registered before anything can fail, including the checks of the flags, so that
+	// every install is reported. The node's details are filled in once they're prompted for, and left
+	// out if setup fails before then. The webhook is only notified if its own flags are valid.
+	var nodeID, tunnelServer, hostname string
+	util.OnExit(func() {
+		r := newInstallReport(started, status == 0, nodeID, hostname, tunnelServer, *composeMode)
+		writeReport(r, *reportPath, *reportStdout)
+		if webhookOpts.validate() != nil {
+			return
+		}
+		if notifier := webhookOpts.client(); notifier != nil {
+			notify(notifier, r)
+		}
+	})
+
+	util.Step("flags")
+	if err := util.ValidateUsername(*localUser); err != nil {
+		util.Critical(err.Error())
+		util.Exit(1)
+	}
+	g.LocalUser = *localUser
+	if *rollbackOnFailure {
+		util.EnableRollback()
+	}
+	if err := webhookOpts.validate(); err != nil {
+		util.Critical(err.Error())
+		util.Exit(1)
+	}
+	if !util.ValidSudoPolicy(*sudoPolicy) {
+		util.Critical("Invalid sudo policy %s. Must be one of: full, commands, none", *sudoPolicy)
+		util.Exit(1)
+	}
+
+	if !docker.ValidComposeMode(*composeMode) {
+		util.Critical("Invalid Docker Compose version %s. Must be one of: auto, v1, v2", *composeMode)
+		util.Exit(1)
+	}
+
+	isRoot := util.IsRoot()
+
+	if !isRoot {
+		util.Critical("Setup must be run with root privileges. Try again with sudo.")
+		util.Exit(1)
+	}
+
+	// Detect the OS before prompting for anything, so that an unsupported OS fails fast. Nothing is
+	// changed until the pre-flight checks pass.
+	util.Step("detect-os")
+	osr, err := util.DetectOS()
+	util.Check("Error detecting OS: ", err)
+	util.Packages, err = util.NewPackageManager(osr)
+	util.Check("Unsupported OS: ", err)
+	util.Info("Detected %s", osr.String())
+
+	util.Step("node")
+	if !util.Quiet() {
+		blue := color.New(color.Bold, color.FgBlue).SprintFunc()
+		yellow := color.New(color.Bold, color.FgYellow).SprintFunc()
+
+		color.New(color.FgMagenta, color.Bold).Print("\nOrion RMON Raspberry Pi Setup\n\n")
+		color.New(color.FgWhite, color.Bold).Println("You'll need:")
+
+		fmt.Fprintf(util.Console(), `
+  - %s of the unit, a unique 2 digit number between 1-99.
+  - %s of the remote SSH tunnel server.
+
+`, blue("ID number"), yellow("FQDN"))
+	}
+
+	nodeID = GetNodeID()
+	tunnelServer = GetTunnelServer()
+	hostname = nodeHostname(nodeID)
+
+	if len(hostname) > 255 {
+		util.Critical("Hostname must be no more than 255 characters long. Hostname %s is %d characters long", hostname, len(hostname))
+		util.Exit(1)
+	}
+
+	// The configuration is downloaded with the container name, which AppNeta names the appliance after.
+	cn := nameOpts.render(nodeID)
+
+	appNetaURL := *appNetaOpts.url
+	if *bundlePath != "" {
+		appNetaURL = ""
+	}
+	util.Step("preflight")
+	preflight(preflightOptions{OS: osr, TunnelServer: tunnelServer, AppNetaURL: appNetaURL, MemoryLimit: *memory != ""})
+
+	util.Step("user")
+	util.EnsureUser(g.LocalUser)
+
+	fmt.Fprintln(util.Console())
+	util.Step("hostname")
+	util.SetHostname(hostname)
+	util.Step("timezone")
+	util.SetTimezone()
+	util.Step("dependencies")
+	util.Dependencies(osr)
+	util.Step("sudoers")
+	util.AddToSudoers(g.LocalUser, *sudoPolicy)
+	util.ScaffoldRoot()
+
+	util.Step("docker")
+	docker.Install(osr)
+	docker.CreateGroup(g.LocalUser)
+	docker.EnableStartup()
+
+	util.Step("compose")
+	compose := docker.InstallCompose(*composeMode)
+
+	// The AppNeta bundle contains secrets, so it's only extracted to a private temporary directory,
+	// which is removed on exit, even if setup fails.
+	util.Step("appneta-bundle")
+	workDir := util.PrivateTempDir("rmon-appneta-")
+	var bundleDir string
+	images := *imagesPath
+	if *bundlePath != "" {
+		var bundled string
+		bundleDir, bundled = docker.UseBundle(*bundlePath, cn, workDir)
+		if images == "" {
+			images = bundled
+		}
+	} else {
+		bundleDir = docker.GetCompose(appNetaOpts.client(), cn, workDir)
+	}
+	util.Step("appneta-container")
+	docker.SetupCompose(bundleDir, images, docker.CredentialStore{ConfigFile: docker.RootDockerConfig, Helper: *credentialHelper})
+	systemd.Root().StopService("appneta-cmp")
+	docker.Scaffold(bundleDir, docker.ComposeOptions{
+		ContainerName: cn,
+		Labels: map[string]string{
+			"com.stellaraf.rmon.hostname": hostname,
+			"com.stellaraf.rmon.node-id":  nodeID,
+		},
+		Restart:    *restart,
+		LogMaxSize: *logMaxSize,
+		LogMaxFile: *logMaxFile,
+		CPUs:       *cpus,
+		Memory:     *memory,
+	})
+	systemd.DockerCompose(compose.Command(), images != "")
+	docker.Verify()
+
+	util.Step("ssh-tunnel")
+	util.ScaffoldUser()
+	util.CheckSSHKeys()
+	systemd.AutoSSH(nodeID, tunnelServer)
+	util.EndStep()
+	status = 0
+
+	util.Success("Setup complete!")
+	util.Exit(status)
+}
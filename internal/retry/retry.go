@@ -0,0 +1,125 @@
+/*
+Package retry retries failed requests with exponential backoff, for the AppNeta & webhook clients.
+*/
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultMinBackoff is the wait before the first retry if a Policy doesn't set MinBackoff.
+const DefaultMinBackoff time.Duration = 2 * time.Second
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Policy determines which errors are retried, how many times & how long to wait between retries.
+type Policy struct {
+	// Retries is the number of times a failed call is retried.
+	Retries int
+	// MinBackoff & MaxBackoff bound the exponential backoff between retries. If MinBackoff isn't
+	// set, DefaultMinBackoff is used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Retriable determines if an error may not recur if the call is retried.
+	Retriable func(err error) bool
+	// OnRetry, if set, is called before each retry with the attempt number, the time until the
+	// retry & the error which caused it.
+	OnRetry func(attempt int, wait time.Duration, err error)
+}
+
+/*
+Transient determines if a request failed because of the network, in a way which may not recur if it
+is retried: a timeout, a reset or refused connection or a truncated response. Other errors, e.g. a
+DNS failure, an invalid certificate or a canceled request, aren't transient. Error responses are
+classified by each client, by their status.
+*/
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+/*
+WithTimeout limits ctx to timeout, e.g. a client's per-attempt timeout or total deadline. If timeout
+isn't positive, ctx isn't limited, but the returned context can still be canceled.
+*/
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+/*
+Backoff gets the wait before retry number attempt (starting at 1). The wait doubles with each
+attempt from min up to max, and a random jitter of up to half of the wait is subtracted so that many
+nodes retrying at once don't do so in lockstep.
+*/
+func Backoff(min, max time.Duration, attempt int) time.Duration {
+	if max < min {
+		max = min
+	}
+	wait := min
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	half := int64(wait / 2)
+	if half > 0 {
+		jitterMu.Lock()
+		wait -= time.Duration(jitter.Int63n(half))
+		jitterMu.Unlock()
+	}
+	return wait
+}
+
+/*
+Do calls f until it succeeds, returns an error which isn't Retriable, the retries are exhausted, or
+ctx is done. If ctx has a deadline which would pass before the next retry, Do gives up early.
+*/
+func Do(ctx context.Context, p Policy, f func() error) error {
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = DefaultMinBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !p.Retriable(err) {
+			return err
+		}
+		if ctx.Err() != nil || attempt > p.Retries {
+			return fmt.Errorf("gave up after %d attempt(s): %w", attempt, err)
+		}
+
+		wait := Backoff(p.MinBackoff, p.MaxBackoff, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("gave up after %d attempt(s), the deadline would be exceeded before the next retry: %w", attempt, err)
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, wait, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up after %d attempt(s): %w", attempt, err)
+		case <-time.After(wait):
+		}
+	}
+}
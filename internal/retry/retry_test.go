@@ -0,0 +1,148 @@
+package retry
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func retriable(err error) bool { return err == errTransient }
+
+func TestBackoff(t *testing.T) {
+	min, max := 100*time.Millisecond, time.Second
+	tests := []struct {
+		attempt int
+		wait    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{10, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			// Up to half of the wait is subtracted as jitter.
+			if got := Backoff(min, max, tt.attempt); got > tt.wait || got <= tt.wait/2 {
+				t.Fatalf("attempt %d waited %s, want (%s, %s]", tt.attempt, got, tt.wait/2, tt.wait)
+			}
+		}
+	}
+	if got := Backoff(time.Second, 0, 3); got > time.Second {
+		t.Errorf("a max below min waited %s, want at most %s", got, time.Second)
+	}
+}
+
+func TestDo(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	tests := []struct {
+		name     string
+		errs     []error
+		retries  int
+		err      error
+		attempts int
+	}{
+		{"succeeds", nil, 3, nil, 1},
+		{"succeeds after retries", []error{errTransient, errTransient}, 3, nil, 3},
+		{"not retriable", []error{errPermanent}, 3, errPermanent, 1},
+		{"retries exhausted", []error{errTransient, errTransient, errTransient}, 2, errTransient, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts, retries int
+			p := Policy{
+				Retries:    tt.retries,
+				MinBackoff: time.Millisecond,
+				MaxBackoff: time.Millisecond,
+				Retriable:  retriable,
+				OnRetry:    func(attempt int, wait time.Duration, err error) { retries++ },
+			}
+			err := Do(context.Background(), p, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("made %d attempt(s), want %d", attempts, tt.attempts)
+			}
+			if retries != attempts-1 {
+				t.Errorf("OnRetry was called %d time(s) for %d attempt(s)", retries, attempts)
+			}
+		})
+	}
+}
+
+func TestDoDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	attempts := 0
+	p := Policy{Retries: 5, MinBackoff: time.Second, MaxBackoff: time.Second, Retriable: retriable}
+	start := time.Now()
+	err := Do(ctx, p, func() error {
+		attempts++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) || attempts != 1 {
+		t.Errorf("got %v after %d attempt(s), want to give up after 1", err, attempts)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("waited for a retry which would pass the deadline")
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", fmt.Errorf("post: %w", context.Canceled), false},
+		{"deadline exceeded", &url.Error{Op: "Post", URL: "u", Err: context.DeadlineExceeded}, true},
+		{"dial timeout", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ETIMEDOUT)}, true},
+		{"connection reset", &url.Error{Op: "Post", URL: "u", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, true},
+		{"connection refused", &url.Error{Op: "Post", URL: "u", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, true},
+		{"truncated response", io.ErrUnexpectedEOF, true},
+		{"DNS failure", &url.Error{Op: "Post", URL: "u", Err: &net.DNSError{Err: "no such host", Name: "appneta.invalid"}}, false},
+		{"invalid certificate", &url.Error{Op: "Post", URL: "u", Err: x509.UnknownAuthorityError{}}, false},
+		{"other error", errors.New("invalid URL"), false},
+	}
+	for _, tt := range tests {
+		if got := Transient(tt.err); got != tt.want {
+			t.Errorf("%s: Transient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := WithTimeout(context.Background(), time.Minute)
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("a positive timeout didn't set a deadline")
+	}
+	cancel()
+
+	ctx, cancel = WithTimeout(context.Background(), 0)
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("a zero timeout set a deadline")
+	}
+	cancel()
+	if ctx.Err() != context.Canceled {
+		t.Error("an unlimited context wasn't canceled")
+	}
+}
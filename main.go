@@ -3,34 +3,38 @@ package main
 import (
 	"fmt"
 	"os"
-	"path"
 	"regexp"
 	"strings"
 
-	docker "github.com/stellaraf/rmon-node-setup/docker"
 	g "github.com/stellaraf/rmon-node-setup/globals"
-	systemd "github.com/stellaraf/rmon-node-setup/systemd"
 	util "github.com/stellaraf/rmon-node-setup/util"
-
-	color "github.com/fatih/color"
 )
 
+var nodeIDPattern = regexp.MustCompile(`^[0-9]{1,2}$`)
+
+// ParseNodeID validates a node ID & pads it to 2 digits.
+func ParseNodeID(nodeID string) (string, bool) {
+	if !nodeIDPattern.MatchString(nodeID) {
+		return "", false
+	}
+	return fmt.Sprintf("%02s", nodeID), true
+}
+
 // GetNodeID prompts the user for the 2 digit node ID.
 func GetNodeID() (nodeID string) {
-	fmt.Print("Node ID (2 digit number): ")
+	fmt.Fprint(util.Console(), "Node ID (2 digit number): ")
 	fmt.Scanf("%s", &nodeID)
-	matched, err := regexp.MatchString(`^[0-9]{1,2}$`, nodeID)
-	util.Check("Error checking Node ID format: ", err)
-	if !matched {
+	nodeID, valid := ParseNodeID(nodeID)
+	if !valid {
 		util.Warning("Invalid Node ID. Node ID must be a 2 digit number.")
 		return GetNodeID()
 	}
-	return fmt.Sprintf("%02s", nodeID)
+	return nodeID
 }
 
 // GetTunnelServer prompts the user for the remote SSH tunnel server.
 func GetTunnelServer() (tunnelServer string) {
-	fmt.Print("SSH Tunnel Server (FQDN): ")
+	fmt.Fprint(util.Console(), "SSH Tunnel Server (FQDN): ")
 	fmt.Scanf("%s", &tunnelServer)
 	parts := strings.Split(tunnelServer, ".")
 	if len(parts) < 3 {
@@ -40,87 +44,73 @@ func GetTunnelServer() (tunnelServer string) {
 	return
 }
 
-// GetAPIKey prompts the user for the AppNeta API Key.
-func GetAPIKey() (apiKey string) {
-	fmt.Print("Enter the AppNeta API Key from IT Glue: ")
-	fmt.Scanf("%s", &apiKey)
+// GetAPIKey prompts the user for the AppNeta API Key, without echoing it.
+func GetAPIKey() util.Secret {
+	apiKey, err := util.ReadSecret("Enter the AppNeta API Key from IT Glue: ")
+	util.Check("Error reading AppNeta API Key", err)
 
-	if len(apiKey) != 32 {
-		util.Warning("Invalid API Key - expected 32 characters. Got %d characters", len(apiKey))
+	if err := validateAPIKey(apiKey); err != nil {
+		util.Warning(err.Error())
 		return GetAPIKey()
 	}
 	return apiKey
 }
 
-func main() {
-
-	isRoot := util.IsRoot()
-
-	if !isRoot {
-		util.Critical("Setup must be run with root privileges. Try again with sudo.")
-		os.Exit(1)
+// validateAPIKey checks the length of an AppNeta API Key.
+func validateAPIKey(apiKey util.Secret) error {
+	if n := len(apiKey.Reveal()); n != 32 {
+		return fmt.Errorf("Invalid API Key - expected 32 characters. Got %d characters", n)
 	}
+	return nil
+}
 
-	status := 1
-
-	util.AddToSudoers(g.LocalUser)
-
-	blue := color.New(color.Bold, color.FgBlue).SprintFunc()
-	yellow := color.New(color.Bold, color.FgYellow).SprintFunc()
+// nodeHostname gets the FQDN of a node from its node ID.
+func nodeHostname(nodeID string) string {
+	return fmt.Sprintf("rpi%s.%s", nodeID, g.HostnameBase)
+}
 
-	color.New(color.FgMagenta, color.Bold).Print("\nOrion RMON Raspberry Pi Setup\n\n")
-	color.New(color.FgWhite, color.Bold).Println("You'll need:")
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [command] [flags]
 
-	fmt.Printf(`
-  - %s of the unit, a unique 2 digit number between 1-99.
-  - %s of the remote SSH tunnel server.
+Commands:
+  install     Set up this node (default)
+  bundle      Create an offline bundle of the AppNeta configuration & images for a node
+  status      Show whether this node's AppNeta appliance is registered & connected
+  register    Register this node's AppNeta appliance, and wait for it to connect
+  deregister  Delete this node's AppNeta appliance, e.g. when the node is decommissioned
+  restore     Restore a file managed by setup from a backup
 
-`, blue("ID number"), yellow("FQDN"))
+Run '%s <command> -h' for the flags of a command.
+`, os.Args[0], os.Args[0])
+}
 
-	nodeID := GetNodeID()
-	tunnelServer := GetTunnelServer()
-	hostname := fmt.Sprintf("rpi%s.%s", nodeID, g.HostnameBase)
+func main() {
+	util.HandleSignals()
 
-	if len(hostname) > 255 {
-		util.Critical("Hostname must be no more than 255 characters long. Hostname %s is %s characters long", hostname, len(hostname))
-		os.Exit(1)
+	command := "install"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	hostnameDashes := strings.ReplaceAll(hostname, ".", "-")
-	outDir := fmt.Sprintf(g.HomeDir, g.LocalUser)
-
-	fmt.Println()
-	util.SetHostname(hostname)
-	util.SetTimezone()
-	util.Dependencies()
-	util.ScaffoldRoot()
-
-	docker.Install()
-	docker.CreateGroup(g.LocalUser)
-	docker.EnableStartup()
-
-	apiKey := GetAPIKey()
-	docker.InstallCompose()
-	docker.GetCompose(apiKey, hostnameDashes, outDir)
-	docker.SetupCompose(hostnameDashes, outDir)
-	systemd.Root().StopService("appneta-cmp")
-	docker.Scaffold(hostnameDashes)
-	systemd.DockerCompose()
-
-	dlDir := path.Join(outDir, hostnameDashes)
-	util.Info("Cleaning up %s", dlDir)
-	err := os.RemoveAll(dlDir)
-	util.Check("Error deleting %s", err, dlDir)
-
-	util.RunAs(g.LocalUser, func() {
-		util.ScaffoldUser()
-		util.CheckSSHKeys()
-
-		systemd.AutoSSH(nodeID, tunnelServer)
-
-	})()
-	status = 0
-
-	util.Success("Setup complete!")
-	os.Exit(status)
+	switch command {
+	case "install":
+		install(args)
+	case "bundle":
+		bundle(args)
+	case "status":
+		status(args)
+	case "register":
+		register(args)
+	case "deregister", "delete":
+		deregister(args)
+	case "restore":
+		restore(args)
+	case "help":
+		usage()
+	default:
+		usage()
+		util.Exit(2)
+	}
+	util.Exit(0)
 }
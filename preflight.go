@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	docker "github.com/stellaraf/rmon-node-setup/docker"
+	g "github.com/stellaraf/rmon-node-setup/globals"
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+const (
+	gib int64 = 1 << 30
+	mib int64 = 1 << 20
+)
+
+// preflightOptions are the parts of the install configuration which the pre-flight checks depend on.
+type preflightOptions struct {
+	OS           util.OSRelease
+	TunnelServer string
+	// AppNetaURL is the base URL of the AppNeta portal, which is empty for an offline install.
+	AppNetaURL string
+	// MemoryLimit is true if a memory limit is set on the AppNeta container.
+	MemoryLimit bool
+}
+
+// preflightChecks gets the checks which are run before setup makes any changes.
+func preflightChecks(opts preflightOptions) []util.PreflightCheck {
+	checks := []util.PreflightCheck{
+		{Name: "Architecture", Run: func() (util.PreflightStatus, string) { return checkArch(opts.OS) }},
+		{Name: "Disk space (/)", Run: func() (util.PreflightStatus, string) { return checkDisk("/", 512*mib, gib) }},
+		{Name: "Disk space (/var/lib/docker)", Run: func() (util.PreflightStatus, string) { return checkDisk("/var/lib/docker", 2*gib, 4*gib) }},
+		{Name: "Memory", Run: func() (util.PreflightStatus, string) { return checkMemory(512*mib, gib) }},
+		{Name: "cgroups", Run: func() (util.PreflightStatus, string) { return checkCgroups(opts.MemoryLimit) }},
+		{Name: "DNS (tunnel server)", Run: func() (util.PreflightStatus, string) { return checkDNS(opts.TunnelServer, true) }},
+		{Name: "DNS (Docker)", Run: func() (util.PreflightStatus, string) {
+			// Docker's repository isn't needed if Docker is already installed.
+			return checkDNS("download.docker.com", !util.IsInstalled("docker-ce"))
+		}},
+	}
+	clockURL := "https://download.docker.com"
+	if opts.AppNetaURL != "" {
+		clockURL = opts.AppNetaURL
+		checks = append(checks, util.PreflightCheck{Name: "DNS (AppNeta)", Run: func() (util.PreflightStatus, string) {
+			u, err := url.Parse(opts.AppNetaURL)
+			if err != nil {
+				return util.PreflightFail, err.Error()
+			}
+			return checkDNS(u.Hostname(), true)
+		}})
+	}
+	checks = append(checks,
+		util.PreflightCheck{Name: "Clock", Run: func() (util.PreflightStatus, string) { return checkClock(clockURL) }},
+		util.PreflightCheck{Name: "Local user", Run: checkUser},
+	)
+	return checks
+}
+
+// preflight runs the pre-flight checks, and exits if any of them failed.
+func preflight(opts preflightOptions) {
+	util.Info("Running pre-flight checks...")
+	if _, ok := util.RunPreflight(preflightChecks(opts)); !ok {
+		util.Critical("Pre-flight checks failed, no changes have been made")
+		util.Exit(1)
+	}
+	util.Success("Pre-flight checks passed")
+}
+
+func checkArch(osr util.OSRelease) (util.PreflightStatus, string) {
+	arch, repoOS, err := docker.SupportedArch(osr)
+	if err != nil {
+		return util.PreflightFail, err.Error()
+	}
+	return util.PreflightPass, fmt.Sprintf("%s is supported by Docker's %s repository", arch, repoOS)
+}
+
+/*
+checkDisk checks the free space of the filesystem dir is on. If dir doesn't exist yet, the closest
+parent which does is checked, since that's where dir will be created.
+*/
+func checkDisk(dir string, fail, warn int64) (util.PreflightStatus, string) {
+	for !util.FileExists(dir) && dir != "/" {
+		dir = filepath.Dir(dir)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return util.PreflightFail, err.Error()
+	}
+	free := int64(st.Bavail) * int64(st.Bsize)
+	msg := fmt.Sprintf("%s free", util.FormatBytes(free))
+	switch {
+	case free < fail:
+		return util.PreflightFail, fmt.Sprintf("%s, at least %s is required", msg, util.FormatBytes(fail))
+	case free < warn:
+		return util.PreflightWarn, fmt.Sprintf("%s, at least %s is recommended", msg, util.FormatBytes(warn))
+	}
+	return util.PreflightPass, msg
+}
+
+func checkMemory(fail, warn int64) (util.PreflightStatus, string) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return util.PreflightFail, err.Error()
+	}
+	defer file.Close()
+
+	var total int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			total = kb * 1024
+		}
+	}
+	if total == 0 {
+		return util.PreflightFail, "MemTotal was not found in /proc/meminfo"
+	}
+	msg := fmt.Sprintf("%s total", util.FormatBytes(total))
+	switch {
+	case total < fail:
+		return util.PreflightFail, fmt.Sprintf("%s, at least %s is required", msg, util.FormatBytes(fail))
+	case total < warn:
+		return util.PreflightWarn, fmt.Sprintf("%s, at least %s is recommended", msg, util.FormatBytes(warn))
+	}
+	return util.PreflightPass, msg
+}
+
+/*
+checkCgroups checks that cgroups are available for Docker, and that the memory controller is
+enabled, which it isn't by default on Raspberry Pi OS. Without it, a memory limit isn't enforced.
+*/
+func checkCgroups(memoryLimit bool) (util.PreflightStatus, string) {
+	var version string
+	memory := false
+	if b, err := ioutil.ReadFile("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		version = "v2"
+		for _, c := range strings.Fields(string(b)) {
+			if c == "memory" {
+				memory = true
+			}
+		}
+	} else if file, err := os.Open("/proc/cgroups"); err == nil {
+		defer file.Close()
+		version = "v1"
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// subsys_name hierarchy num_cgroups enabled
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 4 && fields[0] == "memory" && fields[3] == "1" {
+				memory = true
+			}
+		}
+	} else {
+		return util.PreflightFail, "cgroups are not available"
+	}
+
+	if !memory {
+		msg := fmt.Sprintf("cgroup %s is available, but the memory controller is disabled. Add 'cgroup_enable=memory' to the kernel command line", version)
+		if memoryLimit {
+			return util.PreflightFail, msg + " to use --memory"
+		}
+		return util.PreflightWarn, msg
+	}
+	return util.PreflightPass, fmt.Sprintf("cgroup %s with the memory controller", version)
+}
+
+// checkDNS checks that host resolves. If it doesn't & required is false, it's only a warning.
+func checkDNS(host string, required bool) (util.PreflightStatus, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		if required {
+			return util.PreflightFail, err.Error()
+		}
+		return util.PreflightWarn, err.Error()
+	}
+	return util.PreflightPass, fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", "))
+}
+
+/*
+checkClock compares the system clock to the Date header of a server. A skewed clock causes TLS
+certificate validation & AppNeta measurements to fail.
+*/
+func checkClock(serverURL string) (util.PreflightStatus, string) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	res, err := client.Head(serverURL)
+	if err != nil {
+		return util.PreflightWarn, fmt.Sprintf("Unable to check the clock against %s: %v", serverURL, err)
+	}
+	res.Body.Close()
+	date, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		return util.PreflightWarn, fmt.Sprintf("Unable to check the clock against %s: %v", serverURL, err)
+	}
+
+	skew := time.Since(date).Round(time.Second)
+	if skew < 0 {
+		skew = -skew
+	}
+	msg := fmt.Sprintf("%s off from %s", skew.String(), res.Request.URL.Host)
+	switch {
+	case skew > 5*time.Minute:
+		return util.PreflightFail, msg + ", check NTP"
+	case skew > 30*time.Second:
+		return util.PreflightWarn, msg + ", check NTP"
+	}
+	return util.PreflightPass, msg
+}
+
+// checkUser checks the local user, which is created by setup if it doesn't exist.
+func checkUser() (util.PreflightStatus, string) {
+	u, err := user.Lookup(g.LocalUser)
+	if _, ok := err.(user.UnknownUserError); ok {
+		return util.PreflightWarn, fmt.Sprintf("%s doesn't exist, it will be created", g.LocalUser)
+	}
+	if err != nil {
+		return util.PreflightFail, err.Error()
+	}
+	if u.Uid == "0" || u.Gid == "0" {
+		return util.PreflightFail, fmt.Sprintf("%s has UID %s & GID %s, but must not have root's UID or GID", u.Username, u.Uid, u.Gid)
+	}
+	if !util.FileExists(u.HomeDir) {
+		return util.PreflightFail, fmt.Sprintf("%s's home directory %s doesn't exist", u.Username, u.HomeDir)
+	}
+	return util.PreflightPass, fmt.Sprintf("%s exists (UID %s, GID %s, home %s)", u.Username, u.Uid, u.Gid, u.HomeDir)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/stellaraf/rmon-node-setup/docker"
+	systemd "github.com/stellaraf/rmon-node-setup/systemd"
+	util "github.com/stellaraf/rmon-node-setup/util"
+	webhook "github.com/stellaraf/rmon-node-setup/webhook"
+)
+
+// ReportFile is the default path of the report of the last install.
+const ReportFile string = "/var/lib/rmon-node-setup/report.json"
+
+// installReport is the machine-readable report of an install, e.g. for a provisioning tracker.
+type installReport struct {
+	Status            string                `json:"status"`
+	Started           time.Time             `json:"started"`
+	DurationSeconds   float64               `json:"duration_seconds"`
+	Hostname          string                `json:"hostname"`
+	NodeID            string                `json:"node_id,omitempty"`
+	TunnelServer      string                `json:"tunnel_server,omitempty"`
+	TunnelPort        int                   `json:"tunnel_port,omitempty"`
+	SSHKeyFingerprint string                `json:"ssh_key_fingerprint,omitempty"`
+	DockerVersion     string                `json:"docker_version,omitempty"`
+	ComposeVersion    string                `json:"compose_version,omitempty"`
+	ContainerUUID     string                `json:"appneta_container_uuid,omitempty"`
+	ContainerName     string                `json:"appneta_container_name,omitempty"`
+	FailedStep        string                `json:"failed_step,omitempty"`
+	Error             string                `json:"error,omitempty"`
+	Steps             []reportStep          `json:"steps"`
+	Rollback          []util.RollbackResult `json:"rollback,omitempty"`
+}
+
+// reportStep is the outcome & duration of a setup step.
+type reportStep struct {
+	Name            string    `json:"name"`
+	Status          string    `json:"status"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Error           string    `json:"error,omitempty"`
+}
+
+/*
+newInstallReport reports the outcome of an install which started at started. Details which can't be
+read, e.g. because setup failed before Docker was installed, are left out. If setup failed before the
+node ID was prompted for, the node is named by its current hostname.
+*/
+func newInstallReport(started time.Time, succeeded bool, nodeID, hostname, tunnelServer, composeMode string) installReport {
+	r := installReport{
+		Status:       util.StepSucceeded,
+		Started:      started.UTC(),
+		Hostname:     hostname,
+		NodeID:       nodeID,
+		TunnelServer: tunnelServer,
+		Steps:        []reportStep{},
+		Rollback:     util.RollbackResults(),
+	}
+	if nodeID != "" {
+		r.TunnelPort, _ = strconv.Atoi(systemd.TunnelPort(nodeID))
+	}
+	if r.Hostname == "" {
+		r.Hostname, _ = os.Hostname()
+	}
+	if !succeeded {
+		r.Status = util.StepFailed
+	}
+
+	for _, s := range util.StepResults() {
+		r.Steps = append(r.Steps, reportStep{
+			Name:            s.Name,
+			Status:          s.Status,
+			Started:         s.Start.UTC(),
+			DurationSeconds: s.Duration.Seconds(),
+			Error:           s.Error,
+		})
+		if s.Status == util.StepFailed && r.FailedStep == "" {
+			r.FailedStep, r.Error = s.Name, s.Error
+		}
+	}
+
+	if fp, err := util.SSHKeyFingerprint(); err == nil {
+		r.SSHKeyFingerprint = fp
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if v, err := docker.Engine().Version(ctx); err == nil {
+		r.DockerVersion = v.Version
+	}
+	if c, found := docker.DetectCompose(composeMode); found {
+		r.ComposeVersion, _ = c.InstalledVersion()
+	}
+	r.ContainerUUID, r.ContainerName, _ = docker.InstalledContainer()
+
+	r.DurationSeconds = time.Since(started).Seconds()
+	return r
+}
+
+/*
+writeReport writes a report to filename, which is only readable by root, and to stdout if toStdout
+is true.
+*/
+func writeReport(r installReport, filename string, toStdout bool) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		util.Warning("Error encoding install report: %s", err.Error())
+		return
+	}
+	b = append(b, '\n')
+
+	if toStdout {
+		os.Stdout.Write(b)
+	}
+	if filename == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		util.Warning("Error creating %s: %s", filepath.Dir(filename), err.Error())
+		return
+	}
+	if err := util.WriteFile(filename, b, util.FileOptions{Perm: 0600}); err != nil {
+		util.Warning("Error writing install report %s: %s", filename, err.Error())
+		return
+	}
+	util.Info("Wrote install report to %s", filename)
+}
+
+// notification describes the outcome of an install for a webhook.
+func notification(r installReport) webhook.Notification {
+	n := webhook.Notification{Succeeded: r.Status == util.StepSucceeded, Report: r}
+	var lines []string
+	if r.NodeID != "" {
+		lines = append(lines, fmt.Sprintf("Node ID: %s", r.NodeID))
+	}
+	if r.TunnelServer != "" {
+		lines = append(lines, fmt.Sprintf("Tunnel: port %d on %s", r.TunnelPort, r.TunnelServer))
+	}
+	if n.Succeeded {
+		n.Title = fmt.Sprintf("Provisioned %s", r.Hostname)
+		if r.ContainerName != "" {
+			lines = append(lines, fmt.Sprintf("AppNeta container: %s", r.ContainerName))
+		}
+	} else {
+		n.Title = fmt.Sprintf("Provisioning %s failed", r.Hostname)
+		if r.FailedStep != "" {
+			lines = append(lines, fmt.Sprintf("Failed step: %s", r.FailedStep))
+		}
+		if r.Error != "" {
+			lines = append(lines, fmt.Sprintf("Error: %s", r.Error))
+		}
+		if len(r.Rollback) > 0 {
+			lines = append(lines, fmt.Sprintf("Rolled back %d step(s)", len(r.Rollback)))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("Duration: %s", (time.Duration(r.DurationSeconds)*time.Second).String()))
+	n.Text = strings.Join(lines, "\n")
+	return n
+}
+
+// notify posts the report of an install to a webhook. A failed notification doesn't fail the install.
+func notify(c *webhook.Client, r installReport) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if err := c.Send(ctx, notification(r)); err != nil {
+		util.Warning("Error notifying webhook %s:\n%s", c.Host(), err.Error())
+		return
+	}
+	util.Success("Notified webhook %s", c.Host())
+}
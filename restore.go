@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	util "github.com/stellaraf/rmon-node-setup/util"
+)
+
+/*
+restore rolls a file managed by setup back to a previous version. Without a file, the managed files
+which have backups are listed. With --list, the backups of the file are listed instead.
+*/
+func restore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	list := flags.Bool("list", false, "List the backups of the file instead of restoring it")
+	version := flags.String("version", "", "Version (timestamp) of the backup to restore (default: the latest)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s restore [flags] [file]\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	logOpts := addLogFlags(flags)
+	flags.Parse(args)
+	logOpts.apply()
+
+	if !util.IsRoot() {
+		util.Critical("Restore must be run with root privileges. Try again with sudo.")
+		util.Exit(1)
+	}
+
+	if flags.NArg() == 0 {
+		files, err := util.ManagedFiles()
+		util.Check("Error listing backups in %s", err, util.BackupDir)
+		if len(files) == 0 {
+			util.Info("No files have been backed up to %s", util.BackupDir)
+			return
+		}
+		util.Info("Files with backups:")
+		for _, f := range files {
+			fmt.Printf("  %s\n", f)
+		}
+		return
+	}
+
+	filename := flags.Arg(0)
+	if *list {
+		backups, err := util.Backups(filename)
+		util.Check("Error listing backups of %s", err, filename)
+		if len(backups) == 0 {
+			util.Info("%s has no backups", filename)
+			return
+		}
+		util.Info("Backups of %s, newest first:", filename)
+		for _, b := range backups {
+			fmt.Printf("  %s\n", b.Version)
+		}
+		return
+	}
+
+	b, err := util.Restore(filename, *version)
+	util.Check("Error restoring %s", err, filename)
+	util.Success("Restored %s from backup %s", b.File, b.Version)
+	util.Info("Restart or reload any service which uses %s for the change to take effect.", b.File)
+}
@@ -2,9 +2,13 @@ package systemd
 
 import (
 	g "github.com/stellaraf/rmon-node-setup/globals"
-	util "github.com/stellaraf/rmon-node-setup/util"
 )
 
+// TunnelPort gets the port of a node's reverse SSH tunnel on the tunnel server, e.g. 10001 for node 01.
+func TunnelPort(nodeID string) string {
+	return "100" + nodeID
+}
+
 // AutoSSH creates & sets up AutoSSH as a systemd service.
 func AutoSSH(nodeID, tunnelServer string) {
 	service := `[Unit]
@@ -19,20 +23,18 @@ ExecStart=/usr/bin/autossh -M 0 -N \
 	-o "ServerAliveCountMax 3" \
 	-o "ConnectTimeout 10" \
 	-o "ExitOnForwardFailure yes" \
-	-i /home/%s/.ssh/id_rsa \
+	-i %s/.ssh/id_rsa \
 	rmontunnel@%s \
-	-R 100%s:localhost:22
+	-R %s:localhost:22
 Restart=always
 RestartSec=10
 
 [Install]
 WantedBy=default.target
 `
-	util.RunAs(g.LocalUser, func() {
-		u := User()
-		u.WriteSystemd("autossh", service, g.LocalUser, tunnelServer, nodeID)
-		u.ReloadServices()
-		u.EnableService("autossh")
-		u.StartService("autossh")
-	})()
+	u := User()
+	u.WriteSystemd("autossh", service, g.UserHome(), tunnelServer, TunnelPort(nodeID))
+	u.ReloadServices()
+	u.EnableService("autossh")
+	u.StartService("autossh")
 }
@@ -1,14 +1,12 @@
 package systemd
 
-import (
-	"path"
-
-	util "github.com/stellaraf/rmon-node-setup/util"
-)
-
 // DockerCompose creates & sets up the AppNeta Docker Compose image as a systemd service per the docs:
 // docker-compose -f mp-compose.yaml pull && docker-compose -f mp-compose.yaml up -d
-func DockerCompose() {
+//
+// compose is the command used to invoke Docker Compose, e.g. `/usr/bin/docker compose` for the v2
+// plugin or `/usr/local/bin/docker-compose` for v1. If offline is true, a failed pull is ignored so
+// the service starts from the loaded images when the registry is unreachable.
+func DockerCompose(compose string, offline bool) {
 	name := "appneta-cmp"
 	service := `# This file is autogenerated. Do not override.
 [Unit]
@@ -21,19 +19,18 @@ StartLimitIntervalSec=0
 Type=oneshot
 RemainAfterExit=true
 WorkingDirectory=/etc/docker/compose
-ExecStartPre=%s -f appneta-cmp.yaml pull
+ExecStartPre=%s%s -f appneta-cmp.yaml pull
 ExecStart=%s -f appneta-cmp.yaml up -d --remove-orphans
 
 [Install]
 WantedBy=multi-user.target
 `
-	user := util.CurrentUser()
-	bin := path.Join(user.HomeDir, "/.local/bin/docker-compose")
-	if user.Name == "root" {
-		bin = "/usr/local/bin/docker-compose"
+	ignoreFailure := ""
+	if offline {
+		ignoreFailure = "-"
 	}
 	r := Root()
-	r.WriteSystemd(name, service, bin, bin)
+	r.WriteSystemd(name, service, ignoreFailure, compose, compose)
 	r.ReloadServices()
 	r.EnableService(name)
 	r.StartService(name)
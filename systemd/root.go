@@ -47,13 +47,12 @@ func Root() (funcs RootFuncs) {
 
 			formatted := fmt.Sprintf(content, f...)
 
-			file, err := os.Create(filename)
-			util.Check("Error creating %s service file: ", err, name)
-
-			_, writeErr := file.WriteString(formatted)
-			util.Check("Error writing %s service file: ", writeErr, name)
-
-			defer file.Close()
+			// Registered before the file is written, so that systemd is reloaded after it is restored.
+			util.OnRollback("Reload systemd", func() error {
+				return exec.Command("systemctl", "daemon-reload").Run()
+			})
+			err := util.WriteFile(filename, []byte(formatted), util.FileOptions{Perm: 0644})
+			util.Check("Error writing %s service file: ", err, name)
 
 			util.Success("Wrote %s service to %s", name, filename)
 			return
@@ -74,8 +73,14 @@ func Root() (funcs RootFuncs) {
 				util.Check("%s service file is missing (%s)", os.ErrNotExist, name, filename)
 			}
 
+			enabled := exec.Command("systemctl", "is-enabled", "--quiet", serviceName).Run() == nil
 			enable, err := exec.Command("systemctl", "enable", serviceName).CombinedOutput()
 			util.Check("Error enabling %s service:\n%s", err, name, util.AsString(enable))
+			if !enabled {
+				util.OnRollback("Disable & stop "+serviceName, func() error {
+					return exec.Command("systemctl", "disable", "--now", serviceName).Run()
+				})
+			}
 
 			util.Success("Set %s service to start at login", name)
 		},
@@ -106,6 +111,9 @@ func Root() (funcs RootFuncs) {
 				if active {
 					stop, err := exec.Command("systemctl", "stop", serviceName).CombinedOutput()
 					util.Check("Error stopping %s service:\n%s", err, name, util.AsString(stop))
+					util.OnRollback("Start "+serviceName, func() error {
+						return exec.Command("systemctl", "start", serviceName).Run()
+					})
 				}
 
 				active = funcs.CheckService(name)
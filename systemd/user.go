@@ -23,17 +23,15 @@ func User() (funcs UserFuncs) {
 		// CheckService checks if a service is active.
 		CheckService: func(name string) (result bool) {
 			result = false
-			util.RunAs(g.LocalUser, func() {
-				check, err := util.UserCommand("systemctl", "--user", "is-active", name)
-				if err != nil {
-					result = false
-				}
-
-				outputString := util.AsString(check)
-				if outputString == "active" {
-					result = true
-				}
-			})()
+			check, err := util.UserCommand("systemctl", "--user", "is-active", name)
+			if err != nil {
+				result = false
+			}
+
+			outputString := util.AsString(check)
+			if outputString == "active" {
+				result = true
+			}
 			return
 		},
 		// ReloadServices reloads user systemd services.
@@ -44,23 +42,24 @@ func User() (funcs UserFuncs) {
 		// WriteSystemd generates & writes a systemd service file.
 		WriteSystemd: func(name, content string, f ...interface{}) {
 
-			filename := fmt.Sprintf(g.SystemdDir+"/%s.service", g.LocalUser, name)
+			filename := fmt.Sprintf("%s/%s.service", g.UserSystemdDir(), name)
 
 			formatted := fmt.Sprintf(content, f...)
 
-			file, err := os.Create(filename)
-			util.Check("Error creating %s service file: ", err, name)
-
-			_, writeErr := file.WriteString(formatted)
-			util.Check("Error writing %s service file: ", writeErr, name)
-
-			defer file.Close()
+			// Registered before the file is written, so that systemd is reloaded after it is restored.
+			util.OnRollback("Reload "+g.LocalUser+"'s systemd", func() error {
+				_, err := util.UserCommand("systemctl", "--user", "daemon-reload")
+				return err
+			})
+			// The file is written by root & owned by the user, whose systemd reads it.
+			err := util.LocalIdentity().WriteFile(filename, []byte(formatted), 0644)
+			util.Check("Error writing %s service file: ", err, name)
 
 			util.Success("Wrote %s service to %s", name, filename)
 			return
 		},
 		EnableService: func(name string) {
-			filename := fmt.Sprintf(g.SystemdDir+"/%s.service", g.LocalUser, name)
+			filename := fmt.Sprintf("%s/%s.service", g.UserSystemdDir(), name)
 			serviceName := fmt.Sprintf("%s.service", name)
 
 			active := funcs.CheckService(name)
@@ -75,8 +74,15 @@ func User() (funcs UserFuncs) {
 				util.Check("%s service file is missing (%s)", os.ErrNotExist, name, filename)
 			}
 
+			_, notEnabled := util.UserCommand("systemctl", "--user", "is-enabled", "--quiet", serviceName)
 			enable, err := util.UserCommand("systemctl", "--user", "enable", serviceName)
 			util.Check("Error enabling %s service:\n%s", err, name, util.AsString(enable))
+			if notEnabled != nil {
+				util.OnRollback("Disable & stop "+g.LocalUser+"'s "+serviceName, func() error {
+					_, err := util.UserCommand("systemctl", "--user", "disable", "--now", serviceName)
+					return err
+				})
+			}
 
 			util.Success("Set %s service to start at login", name)
 		},
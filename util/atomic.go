@@ -0,0 +1,322 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// BackupDir is the directory the previous versions of files written by WriteFile are kept in. Tests
+// point it at a temporary directory.
+var BackupDir string = "/var/backups/rmon-node-setup"
+
+// backupTimeFormat is the format of the timestamp each backup is named with.
+const backupTimeFormat string = "20060102T150405.000000Z"
+
+// FileOptions are the permissions & owner of a file written by WriteFile.
+type FileOptions struct {
+	Perm os.FileMode
+	// UID & GID are the owner of the file, which is root by default.
+	UID int
+	GID int
+	// Validate, if set, is called with the path of the fully written temporary file before it
+	// replaces the file, e.g. to check it with visudo. If it returns an error, the file is left as-is.
+	Validate func(tmp string) error
+}
+
+// Backup is a previous version of a file written by WriteFile.
+type Backup struct {
+	// File is the path of the file which was backed up.
+	File string
+	// Version is the time the backup was made, in the format of backupTimeFormat.
+	Version string
+	// Path is the path of the backup.
+	Path string
+}
+
+// fileOwner gets the UID & GID of a file.
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return 0, 0
+}
+
+// unchanged determines if filename already has the content, permissions & owner of a write.
+func unchanged(filename string, data []byte, opts FileOptions) bool {
+	info, err := os.Stat(filename)
+	if err != nil || info.Mode().Perm() != opts.Perm.Perm() {
+		return false
+	}
+	if uid, gid := fileOwner(info); uid != opts.UID || gid != opts.GID {
+		return false
+	}
+	existing, err := ioutil.ReadFile(filename)
+	return err == nil && bytes.Equal(existing, data)
+}
+
+/*
+WriteFile replaces a file atomically: data is written to a temporary file in the same directory,
+which is given its permissions & owner, fsynced & renamed over the file, so that the file is never
+partially written or briefly accessible with the wrong permissions, even if setup crashes. The
+temporary file starts with a '.' & contains '.tmp-', so it is ignored by sudo, systemd & APT.
+
+If the file exists with different content, it is first backed up to BackupDir, so that it can be
+restored with Restore. If the content, permissions & owner are unchanged, nothing is written.
+*/
+func WriteFile(filename string, data []byte, opts FileOptions) error {
+	if unchanged(filename, data, opts) {
+		return nil
+	}
+	tmp, err := writeTemp(filename, bytes.NewReader(data), opts.Perm, opts.UID, opts.GID)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if opts.Validate != nil {
+		if err = opts.Validate(tmp); err != nil {
+			return err
+		}
+	}
+
+	backupPath, err := backup(filename)
+	if err != nil {
+		return fmt.Errorf("error backing up %s: %v", filename, err)
+	}
+	if err = replaceFile(tmp, filename); err != nil {
+		return err
+	}
+	onRollbackFile(filename, backupPath)
+	return nil
+}
+
+/*
+writeTemp writes r to a new temporary file in the directory of filename, which is given perm & the
+owner uid:gid before anything is written to it, and fsyncs it. An owner of -1 is left unchanged. The
+temporary file starts with a '.' & contains '.tmp-', so it is ignored by sudo, systemd & APT. The
+caller either renames it over filename with replaceFile, or removes it.
+*/
+func writeTemp(filename string, r io.Reader, perm os.FileMode, uid, gid int) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	if err = f.Chmod(perm); err == nil {
+		err = f.Chown(uid, gid)
+	}
+	if err == nil {
+		_, err = io.Copy(f, r)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error writing %s: %v", f.Name(), err)
+	}
+	return f.Name(), nil
+}
+
+// replaceFile renames tmp over filename, and syncs the directory so that the rename survives a crash.
+func replaceFile(tmp, filename string) error {
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(filename)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// RemoveFile removes a file, after backing it up to BackupDir.
+func RemoveFile(filename string) error {
+	backupPath, err := backup(filename)
+	if err != nil {
+		return fmt.Errorf("error backing up %s: %v", filename, err)
+	}
+	if err = os.Remove(filename); err != nil {
+		return err
+	}
+	onRollbackFile(filename, backupPath)
+	return nil
+}
+
+/*
+onRollbackFile registers the undo action of a write or removal of filename: if it existed before, it
+is restored from backupPath, otherwise it's removed.
+*/
+func onRollbackFile(filename, backupPath string) {
+	if backupPath == "" {
+		OnRollback("Remove "+filename, func() error {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		})
+		return
+	}
+	OnRollback("Restore the previous version of "+filename, func() error {
+		return restoreBackup(backupPath, filename)
+	})
+}
+
+// restoreBackup writes a backup to filename, with the permissions & owner it was backed up with.
+func restoreBackup(backupPath, filename string) error {
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(backupPath)
+	if err != nil {
+		return err
+	}
+	uid, gid := fileOwner(info)
+	return WriteFile(filename, data, FileOptions{Perm: info.Mode().Perm(), UID: uid, GID: gid})
+}
+
+/*
+backup copies a file to BackupDir/<path of the file>/<timestamp>, with the file's permissions &
+owner. BackupDir is only accessible by root, since backups may contain secrets. If the file doesn't
+exist, nothing is backed up.
+*/
+func backup(filename string) (string, error) {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(BackupDir, abs)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err = os.Chmod(BackupDir, 0700); err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(dir, time.Now().UTC().Format(backupTimeFormat))
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	uid, gid := fileOwner(info)
+	if err = f.Chown(uid, gid); err == nil {
+		err = f.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return dst, nil
+}
+
+// Backups lists the backups of a file, newest first.
+func Backups(filename string) ([]Backup, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(BackupDir, abs))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []Backup
+	for _, e := range entries {
+		if _, err := time.Parse(backupTimeFormat, e.Name()); e.Mode().IsRegular() && err == nil {
+			backups = append(backups, Backup{File: abs, Version: e.Name(), Path: filepath.Join(BackupDir, abs, e.Name())})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Version > backups[j].Version })
+	return backups, nil
+}
+
+// ManagedFiles lists the files which have backups.
+func ManagedFiles() ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	err := filepath.Walk(BackupDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == BackupDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if _, err := time.Parse(backupTimeFormat, info.Name()); err != nil {
+			return nil
+		}
+		file := strings.TrimPrefix(filepath.Dir(p), BackupDir)
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+/*
+Restore restores a file from a backup, with the permissions & owner it had when it was backed up. If
+version is empty, the latest backup is restored. The current version is backed up first, so a
+restore can itself be undone.
+*/
+func Restore(filename, version string) (Backup, error) {
+	backups, err := Backups(filename)
+	if err != nil {
+		return Backup{}, err
+	}
+	if len(backups) == 0 {
+		return Backup{}, fmt.Errorf("%s has no backups", filename)
+	}
+	b := backups[0]
+	if version != "" {
+		found := false
+		for _, candidate := range backups {
+			if candidate.Version == version {
+				b, found = candidate, true
+			}
+		}
+		if !found {
+			return Backup{}, fmt.Errorf("%s has no backup %s", filename, version)
+		}
+	}
+
+	return b, restoreBackup(b.Path, b.File)
+}
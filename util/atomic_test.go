@@ -0,0 +1,242 @@
+package util
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+/*
+testFiles points BackupDir at a temporary directory, and creates a directory for the files written
+by a test. The returned func removes both & restores BackupDir.
+*/
+func testFiles(t *testing.T) (dir string, cleanup func()) {
+	t.Helper()
+	root, err := ioutil.TempDir("", "rmon-atomic-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir = filepath.Join(root, "files")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := BackupDir
+	BackupDir = filepath.Join(root, "backups")
+	return dir, func() {
+		BackupDir = previous
+		os.RemoveAll(root)
+	}
+}
+
+// testFileOptions are options which can be written without root, i.e. owned by the current user.
+func testFileOptions(perm os.FileMode) FileOptions {
+	return FileOptions{Perm: perm, UID: os.Getuid(), GID: os.Getgid()}
+}
+
+func readFile(t *testing.T, filename string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// backupContents gets the content of each backup of filename, newest first.
+func backupContents(t *testing.T, filename string) []string {
+	t.Helper()
+	backups, err := Backups(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, b := range backups {
+		contents = append(contents, readFile(t, b.Path))
+	}
+	return contents
+}
+
+// writeVersions writes each version of filename in turn, so each one but the last is backed up.
+func writeVersions(t *testing.T, filename string, versions ...string) {
+	t.Helper()
+	for _, v := range versions {
+		if err := WriteFile(filename, []byte(v), testFileOptions(0640)); err != nil {
+			t.Fatal(err)
+		}
+		// Backups are named by the time they're made, so they must not be made at the same time.
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// assertNoTempFiles checks that no temporary files were left in dir.
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	matches, _ := filepath.Glob(filepath.Join(dir, ".*.tmp-*"))
+	if len(matches) > 0 {
+		t.Errorf("temporary file(s) %v were left behind", matches)
+	}
+}
+
+func TestWriteFileBackup(t *testing.T) {
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "appneta-cmp.service")
+	if err := ioutil.WriteFile(filename, []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(filename, []byte("v2"), testFileOptions(0644)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filename); got != "v2" {
+		t.Errorf("got %q, want v2", got)
+	}
+	if info, _ := os.Stat(filename); info.Mode().Perm() != 0644 {
+		t.Errorf("file has mode %o, want 0644", info.Mode().Perm())
+	}
+	if got := backupContents(t, filename); !reflect.DeepEqual(got, []string{"v1"}) {
+		t.Errorf("got backups %q, want [v1]", got)
+	}
+	backups, _ := Backups(filename)
+	if info, _ := os.Stat(backups[0].Path); info.Mode().Perm() != 0600 {
+		t.Errorf("backup has mode %o, want the original 0600", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(BackupDir); info.Mode().Perm() != 0700 {
+		t.Errorf("%s has mode %o, want 0700", BackupDir, info.Mode().Perm())
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteFileNew(t *testing.T) {
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "docker.list")
+
+	if err := WriteFile(filename, []byte("v1"), testFileOptions(0644)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filename); got != "v1" {
+		t.Errorf("got %q, want v1", got)
+	}
+	if got := backupContents(t, filename); len(got) != 0 {
+		t.Errorf("a new file was backed up: %q", got)
+	}
+}
+
+func TestWriteFileUnchanged(t *testing.T) {
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "docker.list")
+	writeVersions(t, filename, "v1", "v1")
+
+	if got := backupContents(t, filename); len(got) != 0 {
+		t.Errorf("an unchanged file was backed up: %q", got)
+	}
+
+	// A change of permissions alone is a change.
+	if err := WriteFile(filename, []byte("v1"), testFileOptions(0600)); err != nil {
+		t.Fatal(err)
+	}
+	if got := backupContents(t, filename); !reflect.DeepEqual(got, []string{"v1"}) {
+		t.Errorf("got backups %q, want [v1]", got)
+	}
+}
+
+func TestWriteFileValidate(t *testing.T) {
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "rmon")
+	writeVersions(t, filename, "v1")
+
+	invalid := errors.New("syntax error")
+	var validated string
+	opts := testFileOptions(0440)
+	opts.Validate = func(tmp string) error {
+		validated = readFile(t, tmp)
+		return invalid
+	}
+	if err := WriteFile(filename, []byte("v2"), opts); err != invalid {
+		t.Fatalf("got error %v, want %v", err, invalid)
+	}
+	if validated != "v2" {
+		t.Errorf("validated %q, want the new content", validated)
+	}
+	if got := readFile(t, filename); got != "v1" {
+		t.Errorf("a rejected write changed the file to %q", got)
+	}
+	if got := backupContents(t, filename); len(got) != 0 {
+		t.Errorf("a rejected write was backed up: %q", got)
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func TestRemoveFile(t *testing.T) {
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "autossh.service")
+	writeVersions(t, filename, "v1")
+
+	if err := RemoveFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("%s wasn't removed", filename)
+	}
+	if got := backupContents(t, filename); !reflect.DeepEqual(got, []string{"v1"}) {
+		t.Errorf("got backups %q, want [v1]", got)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "appneta-cmp.service")
+	writeVersions(t, filename, "v1", "v2", "v3")
+	backups, err := Backups(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want 2", len(backups))
+	}
+
+	files, err := ManagedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{filename}) {
+		t.Errorf("got managed files %q, want [%s]", files, filename)
+	}
+
+	// The latest backup is restored by default.
+	b, err := Restore(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Version != backups[0].Version || readFile(t, filename) != "v2" {
+		t.Errorf("restored %s to %q, want the latest backup %s", b.Version, readFile(t, filename), backups[0].Version)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	// A specific version can be restored, and the restore is itself backed up.
+	if _, err = Restore(filename, backups[1].Version); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filename); got != "v1" {
+		t.Errorf("restored %s to %q, want v1", backups[1].Version, got)
+	}
+	if got := backupContents(t, filename); !reflect.DeepEqual(got, []string{"v2", "v3", "v2", "v1"}) {
+		t.Errorf("got backups %q, want [v2 v3 v2 v1]", got)
+	}
+
+	if _, err = Restore(filename, "20000101T000000.000000Z"); err == nil {
+		t.Error("restored a version which doesn't exist")
+	}
+	if _, err = Restore(filepath.Join(dir, "missing"), ""); err == nil {
+		t.Error("restored a file which has no backups")
+	}
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	cleanupMu sync.Mutex
+	cleanups  []func()
+)
+
+/*
+OnExit registers a function to be run when the process exits via Exit, including after a failed
+Check or an interrupt. Functions are run in the reverse order they were registered.
+*/
+func OnExit(f func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanups = append(cleanups, f)
+}
+
+// RunCleanups runs & clears the functions registered with OnExit.
+func RunCleanups() {
+	cleanupMu.Lock()
+	fs := cleanups
+	cleanups = nil
+	cleanupMu.Unlock()
+
+	for i := len(fs) - 1; i >= 0; i-- {
+		fs[i]()
+	}
+}
+
+/*
+Exit ends the current step, runs the functions registered with OnExit, and exits with code. If code
+is a failure & rollback is enabled, the completed setup steps are rolled back first.
+*/
+func Exit(code int) {
+	endStepOnExit(code)
+	rollbackOnFailure(code)
+	RunCleanups()
+	os.Exit(code)
+}
+
+// HandleSignals runs the functions registered with OnExit if the process is interrupted.
+func HandleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		s := <-c
+		Warning("Received %s, cleaning up...", s.String())
+		Exit(128 + int(s.(syscall.Signal)))
+	}()
+}
+
+/*
+PrivateTempDir creates a temporary directory which is only accessible by the current user (0700),
+and registers it to be removed on exit.
+*/
+func PrivateTempDir(prefix string) string {
+	dir, err := ioutil.TempDir("", prefix)
+	Check("Error creating temporary directory: ", err)
+	err = os.Chmod(dir, 0700)
+	Check("Error setting permissions on temporary directory %s", err, dir)
+
+	OnExit(func() {
+		if err := os.RemoveAll(dir); err != nil {
+			Warning("Error deleting %s:\n%s", dir, err.Error())
+		} else {
+			Info("Cleaned up %s", dir)
+		}
+	})
+	return dir
+}
@@ -0,0 +1,175 @@
+package util
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxFileSize is the default maximum size of a single extracted file (64 MiB).
+const DefaultMaxFileSize int64 = 64 << 20
+
+// DefaultMaxTotalSize is the default maximum size of all extracted files combined (256 MiB).
+const DefaultMaxTotalSize int64 = 256 << 20
+
+// ExtractOptions controls how an archive is extracted.
+type ExtractOptions struct {
+	// MaxFileSize is the maximum size of a single file. Defaults to DefaultMaxFileSize.
+	MaxFileSize int64
+	// MaxTotalSize is the maximum size of all files combined. Defaults to DefaultMaxTotalSize.
+	MaxTotalSize int64
+	// FileMode is the permission of extracted files. Files which are executable in the archive
+	// are made executable by whoever can read them. Defaults to 0644.
+	FileMode os.FileMode
+	// DirMode is the permission of extracted directories. Defaults to 0755.
+	DirMode os.FileMode
+	// UID & GID are the owner of extracted files & directories. Ownership is not changed if either
+	// is negative.
+	UID int
+	GID int
+}
+
+func (o ExtractOptions) withDefaults() ExtractOptions {
+	if o.MaxFileSize <= 0 {
+		o.MaxFileSize = DefaultMaxFileSize
+	}
+	if o.MaxTotalSize <= 0 {
+		o.MaxTotalSize = DefaultMaxTotalSize
+	}
+	if o.FileMode == 0 {
+		o.FileMode = 0644
+	}
+	if o.DirMode == 0 {
+		o.DirMode = 0755
+	}
+	if o.UID < 0 || o.GID < 0 {
+		o.UID, o.GID = -1, -1
+	}
+	return o
+}
+
+/*
+Extract extracts a tar archive, which may be gzipped, into dst. Entries which would be written
+outside of dst, links, devices & files exceeding the size limits are rejected. Each file is written
+to a temporary file & renamed into place, so a failed extraction never leaves a partially written
+file behind. The paths of the extracted files are returned.
+*/
+func Extract(r io.Reader, dst string, opts ExtractOptions) (files []string, err error) {
+	opts = opts.withDefaults()
+
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dst, opts.DirMode); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip stream: %v", err)
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	tr := tar.NewReader(src)
+	var total int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("invalid tar archive: %v", err)
+		}
+
+		target, err := safeJoin(dst, header.Name)
+		if err != nil {
+			return files, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = mkdirOwned(target, opts); err != nil {
+				return files, err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if header.Size > opts.MaxFileSize {
+				return files, fmt.Errorf("%s is %d bytes, which exceeds the maximum of %d", header.Name, header.Size, opts.MaxFileSize)
+			}
+			total += header.Size
+			if total > opts.MaxTotalSize {
+				return files, fmt.Errorf("archive exceeds the maximum extracted size of %d bytes", opts.MaxTotalSize)
+			}
+			if err = mkdirOwned(filepath.Dir(target), opts); err != nil {
+				return files, err
+			}
+			perm := opts.FileMode
+			if header.FileInfo().Mode()&0111 != 0 {
+				perm |= (perm & 0444) >> 2
+			}
+			if info, err := os.Lstat(target); err == nil && !info.Mode().IsRegular() {
+				return files, fmt.Errorf("%s exists and is not a regular file", target)
+			}
+			var tmp string
+			if tmp, err = writeTemp(target, io.LimitReader(tr, header.Size), perm, opts.UID, opts.GID); err != nil {
+				return files, err
+			}
+			if err = replaceFile(tmp, target); err != nil {
+				os.Remove(tmp)
+				return files, err
+			}
+			files = append(files, target)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return files, fmt.Errorf("%s is not a regular file or directory (type %q)", header.Name, header.Typeflag)
+		}
+	}
+	return files, nil
+}
+
+// safeJoin joins name onto dir, and returns an error if the result is outside of dir.
+func safeJoin(dir, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%s is an absolute path", name)
+	}
+	target := filepath.Join(dir, name)
+	if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s is outside of the destination directory", name)
+	}
+	return target, nil
+}
+
+// mkdirOwned creates a directory & any parents, and applies the owner from opts to the directory.
+func mkdirOwned(dir string, opts ExtractOptions) error {
+	if info, err := os.Lstat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s exists and is not a directory", dir)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dir, opts.DirMode); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, opts.DirMode); err != nil {
+		return err
+	}
+	return chownIf(dir, opts)
+}
+
+func chownIf(path string, opts ExtractOptions) error {
+	if opts.UID < 0 || opts.GID < 0 {
+		return nil
+	}
+	return os.Chown(path, opts.UID, opts.GID)
+}
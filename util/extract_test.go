@@ -0,0 +1,261 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is an entry of an in-memory tar fixture.
+type tarEntry struct {
+	Name     string
+	Type     byte
+	Body     string
+	Size     int64
+	Mode     int64
+	Linkname string
+}
+
+// buildTar creates a tar archive of entries. An entry's Size defaults to the length of its Body.
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.Name, Typeflag: e.Type, Mode: e.Mode, Linkname: e.Linkname, Size: e.Size}
+		if h.Typeflag == 0 {
+			h.Typeflag = tar.TypeReg
+		}
+		if h.Mode == 0 {
+			h.Mode = 0644
+		}
+		if h.Typeflag == tar.TypeReg && h.Size == 0 {
+			h.Size = int64(len(e.Body))
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.Body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "extract-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		opts    ExtractOptions
+		// err is a substring of the expected error, or empty if extraction should succeed.
+		err   string
+		files []string
+	}{
+		{
+			name: "regular files & directories",
+			entries: []tarEntry{
+				{Name: "node/", Type: tar.TypeDir},
+				{Name: "node/.env", Body: "A=1\n"},
+				{Name: "node/sub/mp-compose.yaml", Body: "services: {}\n"},
+			},
+			files: []string{"node/.env", "node/sub/mp-compose.yaml"},
+		},
+		{
+			name:    "parent traversal",
+			entries: []tarEntry{{Name: "../evil", Body: "x"}},
+			err:     "outside of the destination directory",
+		},
+		{
+			name:    "nested parent traversal",
+			entries: []tarEntry{{Name: "node/../../evil", Body: "x"}},
+			err:     "outside of the destination directory",
+		},
+		{
+			name:    "absolute path",
+			entries: []tarEntry{{Name: "/etc/evil", Body: "x"}},
+			err:     "absolute path",
+		},
+		{
+			name:    "symlink escaping the destination",
+			entries: []tarEntry{{Name: "node/link", Type: tar.TypeSymlink, Linkname: "../../../etc/passwd"}},
+			err:     "not a regular file or directory",
+		},
+		{
+			name:    "symlink within the destination",
+			entries: []tarEntry{{Name: "node/link", Type: tar.TypeSymlink, Linkname: ".env"}},
+			err:     "not a regular file or directory",
+		},
+		{
+			name: "hardlink",
+			entries: []tarEntry{
+				{Name: "node/.env", Body: "A=1\n"},
+				{Name: "node/link", Type: tar.TypeLink, Linkname: "node/.env"},
+			},
+			err: "not a regular file or directory",
+		},
+		{
+			name:    "character device",
+			entries: []tarEntry{{Name: "node/null", Type: tar.TypeChar}},
+			err:     "not a regular file or directory",
+		},
+		{
+			name:    "block device",
+			entries: []tarEntry{{Name: "node/sda", Type: tar.TypeBlock}},
+			err:     "not a regular file or directory",
+		},
+		{
+			name:    "fifo",
+			entries: []tarEntry{{Name: "node/fifo", Type: tar.TypeFifo}},
+			err:     "not a regular file or directory",
+		},
+		{
+			name:    "file exceeding the maximum file size",
+			entries: []tarEntry{{Name: "node/big", Body: strings.Repeat("x", 11)}},
+			opts:    ExtractOptions{MaxFileSize: 10},
+			err:     "exceeds the maximum of 10",
+		},
+		{
+			name: "files exceeding the maximum total size",
+			entries: []tarEntry{
+				{Name: "node/a", Body: strings.Repeat("x", 6)},
+				{Name: "node/b", Body: strings.Repeat("x", 6)},
+			},
+			opts: ExtractOptions{MaxTotalSize: 10},
+			err:  "maximum extracted size of 10",
+		},
+		{
+			name: "files within the size limits",
+			entries: []tarEntry{
+				{Name: "node/a", Body: strings.Repeat("x", 5)},
+				{Name: "node/b", Body: strings.Repeat("x", 5)},
+			},
+			opts:  ExtractOptions{MaxFileSize: 5, MaxTotalSize: 10},
+			files: []string{"node/a", "node/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, cleanup := tempDir(t)
+			defer cleanup()
+			dst := filepath.Join(root, "dst")
+
+			tt.opts.UID, tt.opts.GID = -1, -1
+			files, err := Extract(bytes.NewReader(buildTar(t, tt.entries)), dst, tt.opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				if FileExists(filepath.Join(root, "evil")) || FileExists("/etc/evil") {
+					t.Fatal("a file was written outside of the destination directory")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tt.files) {
+				t.Fatalf("extracted %v, want %v", files, tt.files)
+			}
+			for i, f := range tt.files {
+				if want := filepath.Join(dst, f); files[i] != want {
+					t.Errorf("extracted %s, want %s", files[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractGzipAndModes(t *testing.T) {
+	root, cleanup := tempDir(t)
+	defer cleanup()
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(buildTar(t, []tarEntry{
+		{Name: "node/.env", Body: "APPNETA_SERVER_KEY=secret\n", Mode: 0666},
+		{Name: "node/run.sh", Body: "#!/bin/sh\n", Mode: 0755},
+	}))
+	zw.Close()
+
+	files, err := Extract(&gz, root, ExtractOptions{FileMode: 0600, DirMode: 0700, UID: -1, GID: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("extracted %v", files)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(root, "node", ".env"))
+	if err != nil || string(b) != "APPNETA_SERVER_KEY=secret\n" {
+		t.Errorf("unexpected content %q, %v", b, err)
+	}
+	modes := map[string]os.FileMode{"node": 0700, "node/.env": 0600, "node/run.sh": 0700}
+	for name, want := range modes {
+		info, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s has mode %o, want %o", name, got, want)
+		}
+	}
+}
+
+func TestExtractReplace(t *testing.T) {
+	root, cleanup := tempDir(t)
+	defer cleanup()
+	archive := buildTar(t, []tarEntry{{Name: "node/.env", Body: "A=2\n"}})
+	opts := ExtractOptions{UID: -1, GID: -1}
+
+	// An existing file is replaced, without leaving a temporary file behind.
+	if err := os.MkdirAll(filepath.Join(root, "node"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "node", ".env"), []byte("A=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Extract(bytes.NewReader(archive), root, opts); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(root, "node", ".env")); string(b) != "A=2\n" {
+		t.Errorf("unexpected content %q", b)
+	}
+	entries, _ := ioutil.ReadDir(filepath.Join(root, "node"))
+	if len(entries) != 1 {
+		t.Errorf("expected only .env, got %d entries", len(entries))
+	}
+
+	// An existing symlink isn't followed or replaced.
+	link := filepath.Join(root, "node", ".env")
+	os.Remove(link)
+	if err := os.Symlink(filepath.Join(root, "elsewhere"), link); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Extract(bytes.NewReader(archive), root, opts)
+	if err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Fatalf("expected an error extracting over a symlink, got %v", err)
+	}
+	if FileExists(filepath.Join(root, "elsewhere")) {
+		t.Error("the symlink was followed")
+	}
+}
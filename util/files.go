@@ -1,8 +1,7 @@
 package util
 
 import (
-	"fmt"
-	"io"
+	"io/ioutil"
 	"os"
 
 	g "github.com/stellaraf/rmon-node-setup/globals"
@@ -18,43 +17,45 @@ func FileExists(f string) (e bool) {
 	return
 }
 
-// CopyFile copies a file from src to dst and OVERWRITES.
+// CopyFile copies a file from src to dst and OVERWRITES, keeping a backup of dst.
 func CopyFile(src string, dst string) {
-
 	srcInfo, err := os.Stat(src)
-	if os.IsNotExist(err) {
-		Check("Source file %s does not exist", err, src)
-	}
+	Check("Source file %s does not exist", err, src)
 
-	source, err := os.Open(src)
-	Check("Unable to open source file %s", err, src)
-	defer source.Close()
+	data, err := ioutil.ReadFile(src)
+	Check("Unable to read source file %s", err, src)
 
 	if FileExists(dst) {
 		Info("Destination %s already exists and will be overwritten", dst)
 	}
 
-	destination, err := os.Create(dst)
-	Check("Unable to create destination file %s while copying from %s", err, dst, src)
-	defer destination.Close()
-
-	_, err = io.Copy(destination, source)
+	err = WriteFile(dst, data, FileOptions{Perm: srcInfo.Mode().Perm()})
 	Check("Error copying %s to %s", err, src, dst)
+}
 
-	err = os.Chmod(dst, srcInfo.Mode())
-	Check("Error setting permissions on %s to %d", err, dst, srcInfo.Mode())
+/*
+CopyPrivate copies a file from src to dst and OVERWRITES, keeping a backup of dst. dst is only
+readable by root (0600), and it is never readable by anyone else, even while it is being written.
+*/
+func CopyPrivate(src string, dst string) {
+	data, err := ioutil.ReadFile(src)
+	Check("Unable to read source file %s", err, src)
 
-	err = destination.Sync()
-	Check("Error saving contents of copied file %s", err, dst)
-	return
+	if FileExists(dst) {
+		Info("Destination %s already exists and will be overwritten", dst)
+	}
+
+	err = WriteFile(dst, data, FileOptions{Perm: 0600})
+	Check("Error copying %s to %s", err, src, dst)
 }
 
-// ScaffoldUser creates the required directory structure for the non-root user.
+// ScaffoldUser creates the required directory structure for the non-root user, owned by the user.
 func ScaffoldUser() {
-	path := fmt.Sprintf(g.SystemdDir, g.LocalUser)
-	ssh := fmt.Sprintf(g.HomeDir, g.LocalUser) + "/.ssh"
+	id := LocalIdentity()
+	path := g.UserSystemdDir()
+	ssh := g.UserHome() + "/.ssh"
 	if !FileExists(path) {
-		err := os.MkdirAll(path, 0755)
+		err := id.MkdirAll(path, 0755)
 		Check("Error while creating directories: ", err)
 		Success("Created directory '%s'", path)
 	} else {
@@ -62,7 +63,7 @@ func ScaffoldUser() {
 	}
 
 	if !FileExists(ssh) {
-		err := os.Mkdir(ssh, 0700)
+		err := id.MkdirAll(ssh, 0700)
 		Check("Error creating SSH directory at %s", err, ssh)
 	}
 	return
@@ -73,7 +74,7 @@ func ScaffoldRoot() {
 	dirs := []string{"/etc/docker/compose"}
 	for _, d := range dirs {
 		if !FileExists(d) {
-			err := os.MkdirAll(d, 0777)
+			err := os.MkdirAll(d, 0755)
 			Check("Error creating directory %s:\n", err, d)
 			if FileExists(d) {
 				Success("Created directory %s", d)
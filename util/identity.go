@@ -0,0 +1,118 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	g "github.com/stellaraf/rmon-node-setup/globals"
+)
+
+/*
+Identity is a user which commands are run as & files are owned by. The process itself stays root;
+commands are run as child processes with the user's credentials, and files are written by root &
+then chowned to the user.
+*/
+type Identity struct {
+	Username string
+	UID      uint32
+	GID      uint32
+	// Groups are the supplementary group IDs of the user, e.g. the docker group.
+	Groups []uint32
+	Home   string
+}
+
+// LookupIdentity gets the identity of a user from its passwd & group entries.
+func LookupIdentity(name string) (id Identity, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return id, fmt.Errorf("invalid UID %s of user %s: %v", u.Uid, name, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return id, fmt.Errorf("invalid GID %s of user %s: %v", u.Gid, name, err)
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return id, fmt.Errorf("error getting groups of user %s: %v", name, err)
+	}
+	id = Identity{Username: u.Username, UID: uint32(uid), GID: uint32(gid), Home: u.HomeDir}
+	for _, group := range groupIDs {
+		if n, err := strconv.ParseUint(group, 10, 32); err == nil {
+			id.Groups = append(id.Groups, uint32(n))
+		}
+	}
+	return id, nil
+}
+
+// RuntimeDir is the user's systemd runtime directory, which systemctl --user connects to.
+func (id Identity) RuntimeDir() string {
+	return fmt.Sprintf("/run/user/%d", id.UID)
+}
+
+// Env gets the environment of a login session of the user.
+func (id Identity) Env() []string {
+	return []string{
+		"HOME=" + id.Home,
+		"USER=" + id.Username,
+		"LOGNAME=" + id.Username,
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"XDG_RUNTIME_DIR=" + id.RuntimeDir(),
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=" + id.RuntimeDir() + "/bus",
+	}
+}
+
+/*
+Command creates a command which runs as the user, with its UID, GID & supplementary groups, in its
+home directory & with its environment. None of root's environment is passed to it.
+*/
+func (id Identity) Command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = id.Home
+	cmd.Env = id.Env()
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: id.UID, Gid: id.GID, Groups: id.Groups},
+	}
+	return cmd
+}
+
+// Chown sets the owner of a file to the user.
+func (id Identity) Chown(name string) error {
+	return os.Lchown(name, int(id.UID), int(id.GID))
+}
+
+// MkdirAll creates a directory & any missing parents, which are owned by the user.
+func (id Identity) MkdirAll(dir string, perm os.FileMode) error {
+	if FileExists(dir) {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := id.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, perm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return id.Chown(dir)
+}
+
+// WriteFile writes a file which is owned by the user, atomically & with a backup, via WriteFile.
+func (id Identity) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return WriteFile(filename, data, FileOptions{Perm: perm, UID: int(id.UID), GID: int(id.GID)})
+}
+
+// LocalIdentity gets the identity of the local user, and exits if it doesn't exist.
+func LocalIdentity() Identity {
+	id, err := LookupIdentity(g.LocalUser)
+	Check("Error looking up local user %s", err, g.LocalUser)
+	return id
+}
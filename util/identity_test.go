@@ -0,0 +1,146 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testIdentity = Identity{Username: "rmon", UID: 1001, GID: 1002, Groups: []uint32{1002, 998}, Home: "/home/rmon"}
+
+func TestIdentityCommand(t *testing.T) {
+	cmd := testIdentity.Command("systemctl", "--user", "daemon-reload")
+
+	if want := []string{"systemctl", "--user", "daemon-reload"}; !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("args %q, want %q", cmd.Args, want)
+	}
+	if cmd.Dir != "/home/rmon" {
+		t.Errorf("dir %s, want /home/rmon", cmd.Dir)
+	}
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Credential == nil {
+		t.Fatal("no credential is set")
+	}
+	cred := cmd.SysProcAttr.Credential
+	if cred.Uid != 1001 || cred.Gid != 1002 || !reflect.DeepEqual(cred.Groups, []uint32{1002, 998}) {
+		t.Errorf("credential %+v, want UID 1001, GID 1002 & groups [1002 998]", cred)
+	}
+
+	env := map[string]string{}
+	for _, kv := range cmd.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	want := map[string]string{
+		"HOME":                     "/home/rmon",
+		"USER":                     "rmon",
+		"LOGNAME":                  "rmon",
+		"PATH":                     "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"XDG_RUNTIME_DIR":          "/run/user/1001",
+		"DBUS_SESSION_BUS_ADDRESS": "unix:path=/run/user/1001/bus",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("env %v, want %v", env, want)
+	}
+}
+
+// nobodyIdentity gets the identity of nobody. Acting as another user needs root, so the test is
+// skipped if it isn't run as root.
+func nobodyIdentity(t *testing.T) Identity {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("not running as root")
+	}
+	nobody, err := LookupIdentity("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	return nobody
+}
+
+// assertOwner checks that a file is owned by an identity.
+func assertOwner(t *testing.T, name string, id Identity) {
+	t.Helper()
+	info, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := fileOwner(info); uid != int(id.UID) || gid != int(id.GID) {
+		t.Errorf("%s is owned by %d:%d, want %d:%d", name, uid, gid, id.UID, id.GID)
+	}
+}
+
+// TestIdentityCommandRun runs a command as nobody.
+func TestIdentityCommandRun(t *testing.T) {
+	nobody := nobodyIdentity(t)
+	os.Setenv("RMON_IDENTITY_TEST", "leaked")
+	defer os.Unsetenv("RMON_IDENTITY_TEST")
+
+	nobody.Home = "/"
+	out, err := nobody.Command("/bin/sh", "-c", `echo "$(id -u) $(id -g) $HOME $USER $RMON_IDENTITY_TEST"`).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{strconv.FormatUint(uint64(nobody.UID), 10), strconv.FormatUint(uint64(nobody.GID), 10), "/", "nobody", ""}, " ")
+	if got := strings.TrimRight(string(out), "\n"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestIdentityWriteFile(t *testing.T) {
+	nobody := nobodyIdentity(t)
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "authorized_keys")
+
+	// Both a new file & a replaced one are owned by the user.
+	for _, content := range []string{"v1", "v2"} {
+		if err := nobody.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		assertOwner(t, filename, nobody)
+		if got := readFile(t, filename); got != content {
+			t.Errorf("got %q, want %q", got, content)
+		}
+	}
+}
+
+func TestIdentityMkdirAll(t *testing.T) {
+	nobody := nobodyIdentity(t)
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	nested := filepath.Join(dir, ".config", "systemd", "user")
+
+	if err := nobody.MkdirAll(nested, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{filepath.Join(dir, ".config"), filepath.Join(dir, ".config", "systemd"), nested} {
+		assertOwner(t, d, nobody)
+	}
+	// The existing parent is left as-is.
+	assertOwner(t, dir, Identity{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())})
+}
+
+func TestIdentityChown(t *testing.T) {
+	nobody := nobodyIdentity(t)
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "id_ed25519")
+	if err := ioutil.WriteFile(filename, []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "id_ed25519.link")
+	if err := os.Symlink(filename, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{filename, link} {
+		if err := nobody.Chown(name); err != nil {
+			t.Fatal(err)
+		}
+		assertOwner(t, name, nobody)
+	}
+}
@@ -1,20 +1,205 @@
 package util
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	color "github.com/fatih/color"
 )
 
-const reset = iota
+// Level is the severity of a log message.
+type Level int
 
-func unsetChar() string {
-	return fmt.Sprintf("%s[%dm", "\x1b", reset)
+// Log levels, from the most to the least verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
 }
 
-// Logger is a base logger utility to conveniently format console output.
-func Logger(t color.Attribute, m string, f ...interface{}) {
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a log level: debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("invalid log level '%s': must be one of debug, info, warn, error", name)
+}
+
+// Log formats of the console.
+const (
+	// LogFormatText prints messages as colored text. Color is disabled if stdout isn't a terminal.
+	LogFormatText string = "text"
+	// LogFormatJSON prints each message as a JSON object on its own line.
+	LogFormatJSON string = "json"
+)
+
+// LogFile is the file every run is appended to, regardless of the console's log level & format.
+const LogFile string = "/var/log/rmon-node-setup.log"
+
+var (
+	logMu     sync.Mutex
+	logLevel  = LevelInfo
+	logFormat = LogFormatText
+	logFile   *os.File
+	console   io.Writer = os.Stdout
+)
+
+/*
+SetConsole sets the writer of log messages, prompts & other output meant for people, e.g. os.Stderr so
+that stdout only has machine-readable output. Color is enabled if it's a terminal.
+*/
+func SetConsole(w io.Writer) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	console = w
+	color.Output = w
+	if f, ok := w.(*os.File); ok {
+		color.NoColor = os.Getenv("TERM") == "dumb" || !IsTerminal(int(f.Fd()))
+	}
+}
+
+// Console gets the writer of log messages, prompts & other output meant for people.
+func Console() io.Writer {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return console
+}
+
+// SetLogLevel sets the least severe level of the messages printed to the console.
+func SetLogLevel(l Level) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logLevel = l
+}
+
+// SetLogFormat sets the format of the messages printed to the console, LogFormatText or LogFormatJSON.
+func SetLogFormat(format string) error {
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("invalid log format '%s': must be one of text, json", format)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	logFormat = format
+	return nil
+}
+
+// LogFormat gets the format of the messages printed to the console.
+func LogFormat() string {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return logFormat
+}
+
+// Quiet determines if informational console output, e.g. progress, should be left out.
+func Quiet() bool {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return logLevel > LevelInfo || logFormat == LogFormatJSON
+}
+
+/*
+OpenLogFile appends every following message, at every level, to filename with a timestamp & the name
+of the current step. The file is only readable by root, and is closed on exit.
+*/
+func OpenLogFile(filename string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	logMu.Lock()
+	logFile = f
+	logMu.Unlock()
+	fmt.Fprintf(f, "%s ----- %s\n", time.Now().UTC().Format(time.RFC3339Nano), strings.Join(os.Args, " "))
+
+	OnExit(func() {
+		logMu.Lock()
+		defer logMu.Unlock()
+		logFile.Close()
+		logFile = nil
+	})
+	return nil
+}
+
+// logRecord is a message printed to the console with LogFormatJSON.
+type logRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Step    string `json:"step,omitempty"`
+	Message string `json:"message"`
+}
+
+/*
+logMessage writes a message to the log file, and prints it to the console if its level is enabled.
+The format arguments of a text message are highlighted in bold.
+*/
+func logMessage(level Level, t color.Attribute, m string, f ...interface{}) {
+	now := time.Now().UTC()
+	plain := m
+	if f != nil {
+		plain = fmt.Sprintf(m, f...)
+	}
+	step := recordStepMessage(level, plain)
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	writeLogFile(now, level, step, plain)
+	if level < logLevel {
+		return
+	}
+	if logFormat == LogFormatJSON {
+		b, _ := json.Marshal(logRecord{Time: now.Format(time.RFC3339Nano), Level: level.String(), Step: step, Message: plain})
+		fmt.Fprintln(console, string(b))
+		return
+	}
+	if level == LevelDebug {
+		m = "[DEBUG] " + m
+	}
+	printColored(t, m, f...)
+}
+
+// writeLogFile writes a message to the log file, if it's open. logMu must be held.
+func writeLogFile(now time.Time, level Level, step, message string) {
+	if logFile == nil {
+		return
+	}
+	prefix := fmt.Sprintf("%s %-5s ", now.Format(time.RFC3339Nano), strings.ToUpper(level.String()))
+	if step != "" {
+		prefix += "[" + step + "] "
+	}
+	fmt.Fprintln(logFile, prefix+strings.Replace(message, "\n", "\n"+prefix, -1))
+}
+
+// LogFileOnly writes a message to the log file only, e.g. for output which is printed another way.
+func LogFileOnly(level Level, m string, f ...interface{}) {
+	message := fmt.Sprintf(m, f...)
+	step := recordStepMessage(level, message)
+	logMu.Lock()
+	defer logMu.Unlock()
+	writeLogFile(time.Now().UTC(), level, step, message)
+}
+
+// printColored prints a message in a color, with its format arguments highlighted in bold.
+func printColored(t color.Attribute, m string, f ...interface{}) {
 	c := color.New(t)
 	msg := c.Sprint(m)
 
@@ -24,6 +209,11 @@ func Logger(t color.Attribute, m string, f ...interface{}) {
 		bold := color.New(color.Bold).SprintFunc()
 
 		for _, s := range f {
+			// Numbers are left as-is, so that verbs such as %d still apply to them.
+			if isNumber(s) {
+				args = append(args, s)
+				continue
+			}
 
 			/** fatih/color adds a reset code to the end of any Sprintf results, so in order to
 			 *  keep the color from resetting after the format arguments, re-add the color-only
@@ -31,40 +221,55 @@ func Logger(t color.Attribute, m string, f ...interface{}) {
 			 *  with foreground colors.
 			 */
 			e := bold(s)
-			if strings.Contains(e, "\x1b[0m") {
+			if !color.NoColor && strings.Contains(e, "\x1b[0m") {
 				e = e + fmt.Sprintf("\x1b[%dm", t)
 			}
 			args = append(args, e)
 		}
-		c.Printf(msg+"\n", args...)
+		c.Fprintf(console, msg+"\n", args...)
 
 	} else {
-		fmt.Println(msg)
+		fmt.Fprintln(console, msg)
 	}
 }
 
-// Debug logs a blue message to the console.
+// isNumber determines if v is a number.
+func isNumber(v interface{}) bool {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Logger is a base logger utility to conveniently format console output, at the info level.
+func Logger(t color.Attribute, m string, f ...interface{}) {
+	logMessage(LevelInfo, t, m, f...)
+}
+
+// Debug logs a blue message to the console, if the debug level is enabled.
 func Debug(m string, f ...interface{}) {
-	m = "[DEBUG] " + m
-	Logger(color.FgBlue, m, f...)
+	logMessage(LevelDebug, color.FgBlue, m, f...)
 }
 
 // Success logs a green message to the console.
 func Success(m string, f ...interface{}) {
-	Logger(color.FgGreen, m, f...)
+	logMessage(LevelInfo, color.FgGreen, m, f...)
 }
 
 // Info logs a blue message to the console.
 func Info(m string, f ...interface{}) {
-	Logger(color.FgBlue, m, f...)
+	logMessage(LevelInfo, color.FgBlue, m, f...)
 }
 
 // Warning logs a yellow message to the console.
 func Warning(m string, f ...interface{}) {
-	Logger(color.FgYellow, m, f...)
+	logMessage(LevelWarn, color.FgYellow, m, f...)
 }
 
 // Critical logs a red message to the console.
 func Critical(m string, f ...interface{}) {
-	Logger(color.FgRed, m, f...)
+	logMessage(LevelError, color.FgRed, m, f...)
 }
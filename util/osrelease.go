@@ -0,0 +1,118 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	dotenv "github.com/stellaraf/rmon-node-setup/dotenv"
+)
+
+// osReleaseFiles are the locations of the os-release file, in order of precedence.
+var osReleaseFiles = []string{"/etc/os-release", "/usr/lib/os-release"}
+
+// OSRelease is the identity of the operating system, from the os-release file.
+type OSRelease struct {
+	// ID is the lowercase OS identifier, e.g. debian, ubuntu, raspbian or rocky.
+	ID string
+	// IDLike are the identifiers of the OSes this OS is derived from, e.g. rhel centos fedora.
+	IDLike []string
+	// Version is the version number, e.g. 22.04 or 9.3.
+	Version string
+	// Codename is the release codename, e.g. bookworm or jammy. It is empty on RPM-based OSes.
+	Codename string
+	// Name is the human readable name, e.g. Rocky Linux 9.3 (Blue Onyx).
+	Name string
+}
+
+// UnsupportedOSError is returned for an OS which isn't Debian or RHEL based.
+type UnsupportedOSError struct {
+	OS OSRelease
+}
+
+func (e *UnsupportedOSError) Error() string {
+	return fmt.Sprintf("%s is not supported. Supported OSes are Debian, Ubuntu, Raspberry Pi OS & RHEL-based OSes such as Rocky Linux", e.OS.String())
+}
+
+// ParseOSRelease parses the contents of an os-release file.
+func ParseOSRelease(b []byte) (osr OSRelease, err error) {
+	f, err := dotenv.Parse(b)
+	if err != nil {
+		return
+	}
+	vars := f.Map()
+	osr.ID = strings.ToLower(vars["ID"])
+	osr.IDLike = strings.Fields(strings.ToLower(vars["ID_LIKE"]))
+	osr.Version = vars["VERSION_ID"]
+	osr.Codename = vars["VERSION_CODENAME"]
+	if osr.Codename == "" {
+		osr.Codename = vars["UBUNTU_CODENAME"]
+	}
+	osr.Name = vars["PRETTY_NAME"]
+	if osr.ID == "" {
+		err = fmt.Errorf("no ID is set")
+	}
+	return
+}
+
+// DetectOS detects the operating system from its os-release file.
+func DetectOS() (OSRelease, error) {
+	for _, filename := range osReleaseFiles {
+		if !FileExists(filename) {
+			continue
+		}
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return OSRelease{}, fmt.Errorf("error reading %s: %v", filename, err)
+		}
+		osr, err := ParseOSRelease(b)
+		if err != nil {
+			return osr, fmt.Errorf("error reading %s: %v", filename, err)
+		}
+		return osr, nil
+	}
+	return OSRelease{}, fmt.Errorf("no os-release file was found at %s", strings.Join(osReleaseFiles, " or "))
+}
+
+func (o OSRelease) String() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	return strings.TrimSpace(o.ID + " " + o.Version)
+}
+
+// Like determines if the OS is, or is derived from, one of ids.
+func (o OSRelease) Like(ids ...string) bool {
+	for _, id := range ids {
+		if o.ID == id {
+			return true
+		}
+		for _, like := range o.IDLike {
+			if like == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Debian determines if the OS is Debian based, including Ubuntu & Raspberry Pi OS.
+func (o OSRelease) Debian() bool {
+	return o.Like("debian", "ubuntu", "raspbian")
+}
+
+// RHEL determines if the OS is RHEL based, including Fedora, CentOS, Rocky Linux & AlmaLinux.
+func (o OSRelease) RHEL() bool {
+	return o.Like("rhel", "fedora", "centos")
+}
+
+// NewPackageManager creates the package manager of an OS.
+func NewPackageManager(o OSRelease) (PackageManager, error) {
+	switch {
+	case o.Debian():
+		return NewAPT(), nil
+	case o.RHEL():
+		return NewDNF(), nil
+	}
+	return nil, &UnsupportedOSError{OS: o}
+}
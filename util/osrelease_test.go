@@ -0,0 +1,78 @@
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadOSRelease(t *testing.T, name string) OSRelease {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "os-release", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	osr, err := ParseOSRelease(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return osr
+}
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    OSRelease
+		// packages is the type of the OS's package manager, or nil if it is unsupported.
+		packages PackageManager
+	}{
+		{"ubuntu-22.04", OSRelease{"ubuntu", []string{"debian"}, "22.04", "jammy", "Ubuntu 22.04.3 LTS"}, &APT{}},
+		{"debian-12", OSRelease{"debian", []string{}, "12", "bookworm", "Debian GNU/Linux 12 (bookworm)"}, &APT{}},
+		{"raspbian-11", OSRelease{"raspbian", []string{"debian"}, "11", "bullseye", "Raspbian GNU/Linux 11 (bullseye)"}, &APT{}},
+		{"centos-stream-9", OSRelease{"centos", []string{"rhel", "fedora"}, "9", "", "CentOS Stream 9"}, &DNF{}},
+		{"rhel-9.3", OSRelease{"rhel", []string{"fedora"}, "9.3", "", "Red Hat Enterprise Linux 9.3 (Plow)"}, &DNF{}},
+		{"rocky-9.3", OSRelease{"rocky", []string{"rhel", "centos", "fedora"}, "9.3", "", "Rocky Linux 9.3 (Blue Onyx)"}, &DNF{}},
+		{"almalinux-9.3", OSRelease{"almalinux", []string{"rhel", "centos", "fedora"}, "9.3", "", "AlmaLinux 9.3 (Shamrock Pampas Cat)"}, &DNF{}},
+		{"alpine-3.19", OSRelease{"alpine", []string{}, "3.19.0", "", "Alpine Linux v3.19"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			osr := loadOSRelease(t, tt.fixture)
+			if !reflect.DeepEqual(osr, tt.want) {
+				t.Errorf("got %#v, want %#v", osr, tt.want)
+			}
+
+			pm, err := NewPackageManager(osr)
+			if tt.packages == nil {
+				if _, ok := err.(*UnsupportedOSError); !ok {
+					t.Errorf("expected an UnsupportedOSError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.TypeOf(pm) != reflect.TypeOf(tt.packages) {
+				t.Errorf("got package manager %T, want %T", pm, tt.packages)
+			}
+		})
+	}
+}
+
+func TestParseOSReleaseErrors(t *testing.T) {
+	for _, input := range []string{"", "NAME=\"No ID\"\n", "ID=\"unterminated\n"} {
+		if _, err := ParseOSRelease([]byte(input)); err == nil {
+			t.Errorf("expected an error parsing %q", input)
+		}
+	}
+}
+
+func TestOSReleaseString(t *testing.T) {
+	if got := (OSRelease{ID: "rocky", Version: "9.3", Name: "Rocky Linux 9.3 (Blue Onyx)"}).String(); got != "Rocky Linux 9.3 (Blue Onyx)" {
+		t.Errorf("got %q", got)
+	}
+	if got := (OSRelease{ID: "rocky", Version: "9.3"}).String(); got != "rocky 9.3" {
+		t.Errorf("got %q", got)
+	}
+}
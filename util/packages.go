@@ -0,0 +1,258 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+PackageManager installs system packages. It is an interface so that the package manager can be
+swapped out, e.g. for one which doesn't install anything.
+*/
+type PackageManager interface {
+	// Installed gets the installed version of each of pkgs which is installed.
+	Installed(pkgs ...string) (map[string]string, error)
+	// Stale determines if the package index should be updated before installing packages.
+	Stale() bool
+	// Update updates the package index.
+	Update() error
+	// Install installs pkgs.
+	Install(pkgs ...string) error
+}
+
+/*
+CommandRunner runs cmd & gets its output, either stdout or stdout & stderr combined. APT & DNF run
+every command through one, so that it can be replaced, e.g. to check the commands in tests.
+*/
+type CommandRunner func(cmd *exec.Cmd, combined bool) ([]byte, error)
+
+// ExecRunner is a CommandRunner which runs commands with os/exec.
+func ExecRunner(cmd *exec.Cmd, combined bool) ([]byte, error) {
+	if combined {
+		return cmd.CombinedOutput()
+	}
+	return cmd.Output()
+}
+
+// Packages is the package manager used by EnsurePackages & IsInstalled.
+var Packages PackageManager = NewAPT()
+
+// APT is a PackageManager for Debian-based systems, using apt-get & dpkg-query.
+type APT struct {
+	// MaxAge is the age of the package index after which it is stale.
+	MaxAge time.Duration
+	// LockTimeout limits the time spent waiting for another process, e.g. unattended-upgrades, to
+	// release the dpkg lock.
+	LockTimeout time.Duration
+	// ListsDir is the directory of the package index.
+	ListsDir string
+	// SourceDirs are the APT sources & keyrings, which make the package index stale if modified
+	// after it was updated.
+	SourceDirs []string
+	// Run runs apt-get & dpkg-query.
+	Run CommandRunner
+}
+
+// NewAPT creates an APT package manager with the default paths & timeouts.
+func NewAPT() *APT {
+	return &APT{
+		MaxAge:      24 * time.Hour,
+		LockTimeout: 10 * time.Minute,
+		ListsDir:    "/var/lib/apt/lists",
+		SourceDirs:  []string{"/etc/apt/sources.list", "/etc/apt/sources.list.d", "/etc/apt/keyrings"},
+		Run:         ExecRunner,
+	}
+}
+
+// aptLockErrors are printed by apt-get when another process holds the dpkg or APT lock.
+var aptLockErrors = []string{"Could not get lock", "Unable to acquire the dpkg frontend lock", "Unable to lock"}
+
+/*
+run runs apt-get non-interactively. If another process holds the dpkg lock, apt-get is retried until
+the lock is released or LockTimeout elapses.
+*/
+func (a *APT) run(args ...string) error {
+	deadline := time.Now().Add(a.LockTimeout)
+	for {
+		cmd := exec.Command("apt-get", append([]string{"-o", "Dpkg::Options::=--force-confdef", "-o", "Dpkg::Options::=--force-confold"}, args...)...)
+		cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive", "NEEDRESTART_MODE=a")
+		out, err := a.Run(cmd, true)
+		if err == nil {
+			return nil
+		}
+		locked := false
+		for _, e := range aptLockErrors {
+			if strings.Contains(string(out), e) {
+				locked = true
+			}
+		}
+		if !locked || time.Now().After(deadline) {
+			return fmt.Errorf("apt-get %s: %v\n%s", strings.Join(args, " "), err, AsString(out))
+		}
+		Info("Waiting for another process to release the dpkg lock...")
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Installed gets the installed version of each of pkgs which is installed, via dpkg-query.
+func (a *APT) Installed(pkgs ...string) (map[string]string, error) {
+	installed := map[string]string{}
+	if len(pkgs) == 0 {
+		return installed, nil
+	}
+	cmd := exec.Command("dpkg-query", append([]string{"-W", "-f=${Package}\t${db:Status-Status}\t${Version}\n"}, pkgs...)...)
+	out, err := a.Run(cmd, false)
+	// dpkg-query exits with 1 if any package is unknown, but still prints the others.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dpkg-query: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) == 3 && fields[1] == "installed" {
+			installed[fields[0]] = fields[2]
+		}
+	}
+	return installed, nil
+}
+
+// modTime gets the latest modification time of a file, or of a directory & its entries.
+func modTime(name string) (t time.Time) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	t = info.ModTime()
+	if info.IsDir() {
+		entries, _ := filepath.Glob(filepath.Join(name, "*"))
+		for _, e := range entries {
+			if i, err := os.Stat(e); err == nil && i.ModTime().After(t) {
+				t = i.ModTime()
+			}
+		}
+	}
+	return
+}
+
+/*
+Stale determines if the package index is older than MaxAge, or if an APT source or keyring has been
+modified since it was updated, e.g. when Docker's APT repository is added.
+*/
+func (a *APT) Stale() bool {
+	updated := modTime(a.ListsDir)
+	if updated.IsZero() || time.Since(updated) > a.MaxAge {
+		return true
+	}
+	for _, s := range a.SourceDirs {
+		if modTime(s).After(updated) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update updates the package index.
+func (a *APT) Update() error {
+	return a.run("update")
+}
+
+// Install installs pkgs.
+func (a *APT) Install(pkgs ...string) error {
+	return a.run(append([]string{"install", "-y"}, pkgs...)...)
+}
+
+/*
+EnsurePackages installs each of pkgs which isn't already installed, updating the package index first
+if it is stale. The packages which were installed are returned.
+*/
+func EnsurePackages(pkgs ...string) (installed []string, err error) {
+	versions, err := Packages.Installed(pkgs...)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, p := range pkgs {
+		if _, ok := versions[p]; ok {
+			Info("%s %s is already installed", p, versions[p])
+		} else {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	if Packages.Stale() {
+		Info("Updating package index...")
+		if err = Packages.Update(); err != nil {
+			return nil, err
+		}
+	}
+	if err = Packages.Install(missing...); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// DNF is a PackageManager for RHEL-based systems, using dnf & rpm.
+type DNF struct {
+	// Run runs dnf & rpm.
+	Run CommandRunner
+}
+
+// NewDNF creates a DNF package manager.
+func NewDNF() *DNF {
+	return &DNF{Run: ExecRunner}
+}
+
+// Installed gets the installed version of each of pkgs which is installed, via rpm.
+func (d *DNF) Installed(pkgs ...string) (map[string]string, error) {
+	installed := map[string]string{}
+	if len(pkgs) == 0 {
+		return installed, nil
+	}
+	cmd := exec.Command("rpm", append([]string{"-q", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\n"}, pkgs...)...)
+	out, err := d.Run(cmd, false)
+	// rpm exits with the number of packages which aren't installed.
+	if _, ok := err.(*exec.ExitError); ok {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rpm: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Split(line, "\t"); len(fields) == 2 {
+			installed[fields[0]] = fields[1]
+		}
+	}
+	return installed, nil
+}
+
+// Stale is always false, since dnf refreshes expired metadata itself.
+func (d *DNF) Stale() bool {
+	return false
+}
+
+// Update refreshes the dnf metadata cache.
+func (d *DNF) Update() error {
+	return d.run("makecache")
+}
+
+// Install installs pkgs.
+func (d *DNF) Install(pkgs ...string) error {
+	return d.run(append([]string{"install", "-y"}, pkgs...)...)
+}
+
+// run runs dnf non-interactively. dnf waits for the lock of another dnf process itself.
+func (d *DNF) run(args ...string) error {
+	out, err := d.Run(exec.Command("dnf", append([]string{"--assumeyes", "--quiet"}, args...)...), true)
+	if err != nil {
+		return fmt.Errorf("dnf %s: %v\n%s", strings.Join(args, " "), err, AsString(out))
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package util
+
+import (
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeResult is the output of a command run by a fakeRunner.
+type fakeResult struct {
+	out string
+	// exit is the exit code of the command.
+	exit int
+}
+
+// fakeRunner records the commands it is given instead of running them.
+type fakeRunner struct {
+	cmds     []*exec.Cmd
+	combined []bool
+	results  []fakeResult
+}
+
+// exitError gets a real *exec.ExitError with an exit code.
+func exitError(t *testing.T, code int) error {
+	t.Helper()
+	err := exec.Command("/bin/sh", "-c", "exit "+strconv.Itoa(code)).Run()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("expected an ExitError, got %v", err)
+	}
+	return err
+}
+
+func (f *fakeRunner) runner(t *testing.T) CommandRunner {
+	return func(cmd *exec.Cmd, combined bool) ([]byte, error) {
+		f.cmds = append(f.cmds, cmd)
+		f.combined = append(f.combined, combined)
+		if len(f.results) == 0 {
+			return nil, nil
+		}
+		r := f.results[0]
+		f.results = f.results[1:]
+		if r.exit != 0 {
+			return []byte(r.out), exitError(t, r.exit)
+		}
+		return []byte(r.out), nil
+	}
+}
+
+func (f *fakeRunner) args() (args [][]string) {
+	for _, cmd := range f.cmds {
+		args = append(args, cmd.Args)
+	}
+	return
+}
+
+func TestAPTCommands(t *testing.T) {
+	aptGet := []string{"apt-get", "-o", "Dpkg::Options::=--force-confdef", "-o", "Dpkg::Options::=--force-confold"}
+	tests := []struct {
+		name string
+		run  func(a *APT) error
+		want []string
+	}{
+		{"update", func(a *APT) error { return a.Update() }, append(aptGet, "update")},
+		{"install", func(a *APT) error { return a.Install("docker-ce", "git") }, append(aptGet, "install", "-y", "docker-ce", "git")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeRunner{}
+			a := NewAPT()
+			a.Run = f.runner(t)
+			if err := tt.run(a); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.args(); !reflect.DeepEqual(got, [][]string{tt.want}) {
+				t.Errorf("ran %q, want %q", got, tt.want)
+			}
+			if !f.combined[0] {
+				t.Error("apt-get's stderr was not captured")
+			}
+			env := strings.Join(f.cmds[0].Env, "\n")
+			for _, v := range []string{"DEBIAN_FRONTEND=noninteractive", "NEEDRESTART_MODE=a"} {
+				if !strings.Contains(env, v) {
+					t.Errorf("%s is not set", v)
+				}
+			}
+		})
+	}
+}
+
+func TestAPTLocked(t *testing.T) {
+	f := &fakeRunner{results: []fakeResult{{out: "E: Could not get lock /var/lib/dpkg/lock-frontend", exit: 100}}}
+	a := NewAPT()
+	a.Run = f.runner(t)
+	a.LockTimeout = 0
+	err := a.Install("git")
+	if err == nil || !strings.Contains(err.Error(), "Could not get lock") {
+		t.Fatalf("expected a lock error, got %v", err)
+	}
+	if len(f.cmds) != 1 {
+		t.Errorf("apt-get was run %d times after the lock timeout", len(f.cmds))
+	}
+}
+
+func TestAPTInstalled(t *testing.T) {
+	f := &fakeRunner{results: []fakeResult{{
+		out:  "docker-ce\tinstalled\t5:24.0.7-1~debian.12~bookworm\ngit\tnot-installed\t\n",
+		exit: 1,
+	}}}
+	a := NewAPT()
+	a.Run = f.runner(t)
+	got, err := a.Installed("docker-ce", "git", "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"docker-ce": "5:24.0.7-1~debian.12~bookworm"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want := []string{"dpkg-query", "-W", "-f=${Package}\t${db:Status-Status}\t${Version}\n", "docker-ce", "git", "unknown"}
+	if args := f.args(); !reflect.DeepEqual(args, [][]string{want}) {
+		t.Errorf("ran %q, want %q", args, want)
+	}
+	if f.combined[0] {
+		t.Error("dpkg-query's stderr was parsed")
+	}
+}
+
+func TestDNFCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(d *DNF) error
+		want []string
+	}{
+		{"update", func(d *DNF) error { return d.Update() }, []string{"dnf", "--assumeyes", "--quiet", "makecache"}},
+		{"install", func(d *DNF) error { return d.Install("docker-ce", "git") }, []string{"dnf", "--assumeyes", "--quiet", "install", "-y", "docker-ce", "git"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeRunner{}
+			d := NewDNF()
+			d.Run = f.runner(t)
+			if err := tt.run(d); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.args(); !reflect.DeepEqual(got, [][]string{tt.want}) {
+				t.Errorf("ran %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNFError(t *testing.T) {
+	f := &fakeRunner{results: []fakeResult{{out: "Error: Unable to find a match: docker-cee", exit: 1}}}
+	d := NewDNF()
+	d.Run = f.runner(t)
+	err := d.Install("docker-cee")
+	if err == nil || !strings.Contains(err.Error(), "dnf install -y docker-cee") || !strings.Contains(err.Error(), "Unable to find a match") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDNFInstalled(t *testing.T) {
+	f := &fakeRunner{results: []fakeResult{{out: "docker-ce\t24.0.7-1.el9\npackage git is not installed\n", exit: 1}}}
+	d := NewDNF()
+	d.Run = f.runner(t)
+	got, err := d.Installed("docker-ce", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"docker-ce": "24.0.7-1.el9"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want := []string{"rpm", "-q", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\n", "docker-ce", "git"}
+	if args := f.args(); !reflect.DeepEqual(args, [][]string{want}) {
+		t.Errorf("ran %q, want %q", args, want)
+	}
+}
+
+func TestEnsurePackages(t *testing.T) {
+	f := &fakeRunner{results: []fakeResult{{out: "docker-ce\t24.0.7-1.el9\n", exit: 1}}}
+	d := NewDNF()
+	d.Run = f.runner(t)
+	defer func(p PackageManager) { Packages = p }(Packages)
+	Packages = d
+
+	installed, err := EnsurePackages("docker-ce", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(installed, []string{"git"}) {
+		t.Errorf("installed %v, want [git]", installed)
+	}
+	if args := f.args(); len(args) != 2 || !reflect.DeepEqual(args[1], []string{"dnf", "--assumeyes", "--quiet", "install", "-y", "git"}) {
+		t.Errorf("ran %q", args)
+	}
+}
@@ -0,0 +1,116 @@
+package util
+
+import (
+	"fmt"
+
+	color "github.com/fatih/color"
+)
+
+// PreflightStatus is the result of a pre-flight check.
+type PreflightStatus int
+
+const (
+	// PreflightPass means the check passed.
+	PreflightPass PreflightStatus = iota
+	// PreflightWarn means setup can continue, but something may not work as expected.
+	PreflightWarn
+	// PreflightFail means setup can't succeed, so it must not be started.
+	PreflightFail
+)
+
+func (s PreflightStatus) String() string {
+	switch s {
+	case PreflightPass:
+		return "PASS"
+	case PreflightWarn:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+func (s PreflightStatus) color() *color.Color {
+	switch s {
+	case PreflightPass:
+		return color.New(color.FgGreen, color.Bold)
+	case PreflightWarn:
+		return color.New(color.FgYellow, color.Bold)
+	}
+	return color.New(color.FgRed, color.Bold)
+}
+
+func (s PreflightStatus) level() Level {
+	switch s {
+	case PreflightPass:
+		return LevelInfo
+	case PreflightWarn:
+		return LevelWarn
+	}
+	return LevelError
+}
+
+// PreflightCheck is a check of the system which is run before setup makes any changes.
+type PreflightCheck struct {
+	Name string
+	Run  func() (PreflightStatus, string)
+}
+
+// PreflightResult is the result of a PreflightCheck.
+type PreflightResult struct {
+	Name    string
+	Status  PreflightStatus
+	Message string
+}
+
+/*
+RunPreflight runs each check & prints the results as a table. ok is false if any check failed. A
+check which panics is treated as failed, so that one broken check can't skip the others.
+*/
+func RunPreflight(checks []PreflightCheck) (results []PreflightResult, ok bool) {
+	ok = true
+	for _, c := range checks {
+		r := PreflightResult{Name: c.Name}
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					r.Status, r.Message = PreflightFail, fmt.Sprintf("check failed: %v", p)
+				}
+			}()
+			r.Status, r.Message = c.Run()
+		}()
+		if r.Status == PreflightFail {
+			ok = false
+		}
+		results = append(results, r)
+	}
+
+	// With quiet or JSON output, each result is logged instead of printing a table, so that only
+	// warnings & failures are shown when quiet.
+	if Quiet() {
+		for _, r := range results {
+			log := Success
+			switch r.Status {
+			case PreflightWarn:
+				log = Warning
+			case PreflightFail:
+				log = Critical
+			}
+			log("Pre-flight check %s: %s: %s", r.Name, r.Status.String(), r.Message)
+		}
+		return
+	}
+
+	width := len("CHECK")
+	for _, r := range results {
+		if len(r.Name) > width {
+			width = len(r.Name)
+		}
+	}
+	fmt.Fprintf(Console(), "\n  %-*s  %-6s  %s\n", width, "CHECK", "RESULT", "DETAILS")
+	for _, r := range results {
+		// The status is padded before it's colored, since the color codes would count towards the padding.
+		fmt.Fprintf(Console(), "  %-*s  %s  %s\n", width, r.Name, r.Status.color().Sprintf("%-6s", r.Status.String()), r.Message)
+		LogFileOnly(r.Status.level(), "Pre-flight check %s: %s: %s", r.Name, r.Status.String(), r.Message)
+	}
+	fmt.Fprintln(Console())
+	return
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"fmt"
+	"time"
+)
+
+// FormatBytes formats a number of bytes as a human-readable size, e.g. 1.5 MiB.
+func FormatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+/*
+Progress creates a progress reporter which overwrites a single console line with the number of
+bytes received so far, at most a few times per second. Once the total is reached, the line is ended.
+Nothing is printed if console output is quiet or JSON.
+*/
+func Progress(label string) func(received, total int64) {
+	var last time.Time
+	done := Quiet()
+	return func(received, total int64) {
+		complete := total > 0 && received >= total
+		if done || (!complete && time.Since(last) < 250*time.Millisecond) {
+			return
+		}
+		last = time.Now()
+		if total > 0 {
+			fmt.Fprintf(Console(), "\r%s: %s of %s (%d%%)", label, FormatBytes(received), FormatBytes(total), received*100/total)
+		} else {
+			fmt.Fprintf(Console(), "\r%s: %s", label, FormatBytes(received))
+		}
+		if complete {
+			fmt.Fprintln(Console())
+			done = true
+		}
+	}
+}
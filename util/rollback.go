@@ -0,0 +1,91 @@
+package util
+
+import (
+	"sync"
+)
+
+// undoAction reverses a completed setup step.
+type undoAction struct {
+	description string
+	undo        func() error
+}
+
+// RollbackResult is the result of an undo action run by Rollback.
+type RollbackResult struct {
+	Description string `json:"description"`
+	Error       string `json:"error,omitempty"`
+}
+
+var (
+	rollbackMu      sync.Mutex
+	undoActions     []undoAction
+	rollbackEnabled bool
+	rollbackResults []RollbackResult
+)
+
+/*
+OnRollback registers an action which reverses a completed setup step, e.g. restoring the hostname.
+If rollback is enabled & setup fails, the actions are run in the reverse order they were registered.
+*/
+func OnRollback(description string, undo func() error) {
+	rollbackMu.Lock()
+	defer rollbackMu.Unlock()
+	undoActions = append(undoActions, undoAction{description: description, undo: undo})
+}
+
+// EnableRollback enables rollback, so that Exit with a non-zero code reverses the completed steps.
+func EnableRollback() {
+	rollbackMu.Lock()
+	defer rollbackMu.Unlock()
+	rollbackEnabled = true
+}
+
+/*
+Rollback runs & clears the registered undo actions, newest first, and reports what was undone. A
+failed undo action doesn't stop the others from running.
+*/
+func Rollback() []RollbackResult {
+	rollbackMu.Lock()
+	actions := undoActions
+	undoActions = nil
+	rollbackMu.Unlock()
+
+	if len(actions) == 0 {
+		return nil
+	}
+	Warning("Rolling back %d completed step(s)...", len(actions))
+	var results []RollbackResult
+	for i := len(actions) - 1; i >= 0; i-- {
+		a := actions[i]
+		r := RollbackResult{Description: a.description}
+		if err := a.undo(); err != nil {
+			r.Error = err.Error()
+			Warning("Failed to undo: %s\n%s", a.description, err.Error())
+		} else {
+			Success("Undid: %s", a.description)
+		}
+		results = append(results, r)
+	}
+
+	rollbackMu.Lock()
+	rollbackResults = append(rollbackResults, results...)
+	rollbackMu.Unlock()
+	return results
+}
+
+// RollbackResults gets the results of the undo actions which have been run.
+func RollbackResults() []RollbackResult {
+	rollbackMu.Lock()
+	defer rollbackMu.Unlock()
+	return append([]RollbackResult(nil), rollbackResults...)
+}
+
+// rollbackOnFailure rolls back if rollback is enabled & the exit code is a failure.
+func rollbackOnFailure(code int) {
+	rollbackMu.Lock()
+	enabled := rollbackEnabled
+	rollbackMu.Unlock()
+	if enabled && code != 0 {
+		Rollback()
+	}
+}
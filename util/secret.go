@@ -0,0 +1,98 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// redacted is printed in place of a Secret.
+const redacted string = "[redacted]"
+
+/*
+Secret is a sensitive string, such as an API key. It is redacted when formatted or serialized, so
+that it can't end up in logs or reports by accident. Reveal gets the actual value.
+*/
+type Secret string
+
+func (s Secret) String() string {
+	return redacted
+}
+
+// GoString redacts the secret when formatted with %#v.
+func (s Secret) GoString() string {
+	return redacted
+}
+
+// MarshalJSON redacts the secret when serialized to JSON.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+// Reveal gets the value of the secret.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// IsTerminal determines if a file descriptor is a terminal.
+func IsTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
+
+/*
+ReadSecret prints a prompt & reads a line from stdin. If stdin is a terminal, the input isn't echoed,
+so that it doesn't end up in the terminal's scrollback. The terminal is restored even if the process
+is interrupted.
+*/
+func ReadSecret(prompt string) (Secret, error) {
+	fd := int(os.Stdin.Fd())
+	fmt.Fprint(Console(), prompt)
+
+	if state, err := unix.IoctlGetTermios(fd, unix.TCGETS); err == nil {
+		var once sync.Once
+		restore := func() {
+			once.Do(func() {
+				unix.IoctlSetTermios(fd, unix.TCSETS, state)
+			})
+		}
+		OnExit(restore)
+		defer restore()
+		defer fmt.Fprintln(Console())
+
+		noEcho := *state
+		noEcho.Lflag &^= unix.ECHO
+		noEcho.Lflag |= unix.ICANON | unix.ISIG
+		if err = unix.IoctlSetTermios(fd, unix.TCSETS, &noEcho); err != nil {
+			return "", err
+		}
+	}
+
+	line, err := readLine(os.Stdin)
+	if err != nil && line == "" {
+		return "", err
+	}
+	return Secret(strings.TrimSpace(line)), nil
+}
+
+// readLine reads a line a byte at a time, so that input after the line is left for other prompts.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
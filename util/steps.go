@@ -0,0 +1,97 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// Outcomes of a step.
+const (
+	StepSucceeded string = "succeeded"
+	StepFailed    string = "failed"
+)
+
+// StepResult is the outcome of a named setup step.
+type StepResult struct {
+	Name     string
+	Status   string
+	Start    time.Time
+	Duration time.Duration
+	// Error is the last error logged during the step, if it failed.
+	Error string
+}
+
+var (
+	stepMu      sync.Mutex
+	currentStep *StepResult
+	stepResults []StepResult
+)
+
+/*
+Step starts a named setup step, which ends when the next one starts, or on exit. Messages logged
+during the step are labeled with its name in the log file, and its duration is logged when it ends.
+*/
+func Step(name string) {
+	EndStep()
+	stepMu.Lock()
+	currentStep = &StepResult{Name: name, Start: time.Now()}
+	stepMu.Unlock()
+	Debug("Starting step %s", name)
+}
+
+// EndStep ends the current step, if any, successfully.
+func EndStep() {
+	endStep(StepSucceeded)
+}
+
+func endStep(status string) {
+	stepMu.Lock()
+	s := currentStep
+	currentStep = nil
+	if s == nil {
+		stepMu.Unlock()
+		return
+	}
+	s.Status = status
+	if status == StepSucceeded {
+		s.Error = ""
+	}
+	s.Duration = time.Since(s.Start)
+	stepResults = append(stepResults, *s)
+	stepMu.Unlock()
+
+	if status == StepFailed {
+		Critical("Step %s failed after %s", s.Name, s.Duration.Round(time.Millisecond).String())
+	} else {
+		Debug("Step %s %s in %s", s.Name, status, s.Duration.Round(time.Millisecond).String())
+	}
+}
+
+// StepResults gets the results of the steps which have ended.
+func StepResults() []StepResult {
+	stepMu.Lock()
+	defer stepMu.Unlock()
+	return append([]StepResult(nil), stepResults...)
+}
+
+// endStepOnExit ends the current step, which failed if the exit code is a failure.
+func endStepOnExit(code int) {
+	if code != 0 {
+		endStep(StepFailed)
+	} else {
+		endStep(StepSucceeded)
+	}
+}
+
+// recordStepMessage gets the name of the current step, and records an error logged during it.
+func recordStepMessage(level Level, message string) string {
+	stepMu.Lock()
+	defer stepMu.Unlock()
+	if currentStep == nil {
+		return ""
+	}
+	if level == LevelError {
+		currentStep.Error = message
+	}
+	return currentStep.Name
+}
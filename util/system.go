@@ -5,24 +5,36 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 
 	g "github.com/stellaraf/rmon-node-setup/globals"
 )
 
-// Dependencies installs system dependencies.
-func Dependencies() {
-	deps := []string{"autossh", "libffi-dev", "libssl-dev", "python3", "python3-pip"}
-	cmdArgs := []string{"install", "-y"}
-	args := append(cmdArgs, deps...)
+// Dependencies installs system dependencies which aren't already installed.
+func Dependencies(osr OSRelease) {
+	deps := []string{"autossh", "ca-certificates", "gnupg", "libffi-dev", "libssl-dev", "python3", "python3-pip", "sudo"}
+	if osr.RHEL() {
+		deps = []string{"autossh", "ca-certificates", "gnupg2", "libffi-devel", "openssl-devel", "python3", "python3-pip", "sudo"}
+	}
 
-	cmd := exec.Command("apt-get", args...)
 	Info("Installing dependencies...")
-
-	_, err := cmd.CombinedOutput()
+	var installed []string
+	if osr.RHEL() && osr.ID != "fedora" {
+		// autossh is only packaged in EPEL on RHEL-based OSes other than Fedora.
+		epel, err := EnsurePackages("epel-release")
+		Check("Error installing EPEL: ", err)
+		installed = append(installed, epel...)
+	}
+	deps, err := EnsurePackages(deps...)
 	Check("Error installing dependencies: ", err)
+	installed = append(installed, deps...)
 
+	if len(installed) == 0 {
+		Success("Dependencies are already installed")
+		return
+	}
 	styledDeps := []string{}
-	for _, d := range deps {
+	for _, d := range installed {
 		df := fmt.Sprintf("\n  - %s", d)
 		styledDeps = append(styledDeps, df)
 	}
@@ -32,10 +44,16 @@ func Dependencies() {
 
 // SetHostname sets the hostname of this node.
 func SetHostname(hostname string) {
+	previous, _ := os.Hostname()
 	cmd := exec.Command("/usr/bin/hostnamectl", "set-hostname", hostname)
 
 	err := cmd.Run()
 	Check("Error setting hostname: ", err)
+	if previous != "" && previous != hostname {
+		OnRollback("Restore hostname "+previous, func() error {
+			return exec.Command("/usr/bin/hostnamectl", "set-hostname", previous).Run()
+		})
+	}
 
 	Success("Set hostname to %s", hostname)
 }
@@ -43,17 +61,39 @@ func SetHostname(hostname string) {
 // SetTimezone sets the timezone of this node.
 func SetTimezone() {
 	timezone := "Etc/UTC"
+	out, _ := exec.Command("/usr/bin/timedatectl", "show", "--property", "Timezone", "--value").Output()
+	previous := AsString(out)
 	cmd := exec.Command("/usr/bin/timedatectl", "set-timezone", timezone)
 
 	err := cmd.Run()
 	Check("Error setting timezone: ", err)
+	if previous != "" && previous != timezone {
+		OnRollback("Restore timezone "+previous, func() error {
+			return exec.Command("/usr/bin/timedatectl", "set-timezone", previous).Run()
+		})
+	}
 
 	Success("Set timezone to %s", timezone)
 }
 
+// SSHKeyFingerprint gets the SHA256 fingerprint of the local user's SSH public key.
+func SSHKeyFingerprint() (string, error) {
+	pubkey := g.UserHome() + "/.ssh/id_rsa.pub"
+	out, err := exec.Command("ssh-keygen", "-l", "-E", "sha256", "-f", pubkey).Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading the fingerprint of %s: %v", pubkey, err)
+	}
+	// e.g. 3072 SHA256:ZUyPpD7UlWRhG4d/DyYlXCWg6aHWcvN0pgXhNLSzEqc user@host (RSA)
+	fields := strings.Fields(AsString(out))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("unexpected output of ssh-keygen for %s: %s", pubkey, AsString(out))
+	}
+	return fields[1], nil
+}
+
 // CheckSSHKeys ensures SSH keys exist and have the correct permissions.
 func CheckSSHKeys() {
-	privkey := fmt.Sprintf(g.HomeDir, g.LocalUser) + "/.ssh/id_rsa"
+	privkey := g.UserHome() + "/.ssh/id_rsa"
 	pubkey := privkey + ".pub"
 
 	if !FileExists(pubkey) {
@@ -69,17 +109,30 @@ func CheckSSHKeys() {
 
 	mode := stat.Mode()
 	if mode != 0600 {
-		os.Chmod(privkey, 0600)
+		err = os.Chmod(privkey, 0600)
+		Check("Error setting permissions for %s", err, privkey)
 		Info("Set permissions for %s to 0600 (-rw-------)", privkey)
 	}
+
+	// The keys are used by autossh, which runs as the local user.
+	id := LocalIdentity()
+	for _, key := range []string{privkey, pubkey} {
+		info, err := os.Stat(key)
+		Check("Error reading SSH key %s", err, key)
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && (st.Uid != id.UID || st.Gid != id.GID) {
+			err = id.Chown(key)
+			Check("Error setting owner of %s", err, key)
+			Info("Set owner of %s to %s", key, id.Username)
+		}
+	}
 }
 
-// IsInstalled determines if a package is installed by checking to see if it is in $PATH
-func IsInstalled(pkg string) (i bool) {
-	cmd := exec.Command("which", pkg)
-	err := cmd.Run()
-	if err == nil {
-		i = true
+// IsInstalled determines if a package is installed, via the package manager.
+func IsInstalled(pkg string) bool {
+	installed, err := Packages.Installed(pkg)
+	if err != nil {
+		return false
 	}
-	return
+	_, ok := installed[pkg]
+	return ok
 }
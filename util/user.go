@@ -2,50 +2,19 @@ package util
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
 	"path"
-	"runtime"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
-)
-
-// RunAs runs a function with lower privileges.
-func RunAs(u string, f func()) func() {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	uid := 0
-	gid := 0
-	errorMsg := "Error while dropping privileges: "
-	userInfo, err := user.Lookup(u)
-	Check(errorMsg, err)
-
-	uidC, errU := strconv.Atoi(userInfo.Uid)
-	Check(errorMsg, errU)
-	uid = uidC
-
-	gidC, errG := strconv.Atoi(userInfo.Gid)
-	Check(errorMsg, errG)
-	gid = gidC
-
-	_, _, gErr := syscall.Syscall(syscall.SYS_SETGID, uintptr(gid), 0, 0)
-	if gErr != 0 {
-		Check(errorMsg, gErr)
-
-	}
 
-	_, _, uErr := syscall.Syscall(syscall.SYS_SETUID, uintptr(uid), 0, 0)
-	if uErr != 0 {
-		Check(errorMsg, uErr)
-	}
-
-	return f
-}
+	g "github.com/stellaraf/rmon-node-setup/globals"
+)
 
 // IsRoot determines if this package is being run as root.
 func IsRoot() (r bool) {
@@ -53,22 +22,6 @@ func IsRoot() (r bool) {
 	return
 }
 
-// ResetRoot sets the running user back to 0.
-func ResetRoot() {
-
-	_, _, gErr := syscall.Syscall(syscall.SYS_SETGID, 0, 0, 0)
-
-	if gErr != 0 {
-		Check("Error while escalating privileges", gErr)
-	}
-
-	_, _, uErr := syscall.Syscall(syscall.SYS_SETUID, 0, 0, 0)
-	if uErr != 0 {
-		Check("Error while escalating privileges", uErr)
-	}
-
-}
-
 // GetUserGroups gets the current list of groups for which a user is a member.
 func GetUserGroups(user string) (groups []string) {
 	out, err := exec.Command("groups", user).CombinedOutput()
@@ -78,32 +31,84 @@ func GetUserGroups(user string) (groups []string) {
 	return
 }
 
-// AddToSudoers adds a user to the sudoers file.
-func AddToSudoers(user string) (result bool) {
-	sudoers := `# This file is autogenerated. Do not override.
-%s    ALL=(ALL:ALL) ALL
-`
-	result = false
-	line := []byte(fmt.Sprintf(sudoers, user))
+// Sudo policies of the local user.
+const (
+	// SudoFull allows the local user to run any command with sudo, with its password.
+	SudoFull string = "full"
+	// SudoCommands allows the local user to manage the AppNeta & Docker services with sudo, without
+	// a password, and nothing else.
+	SudoCommands string = "commands"
+	// SudoNone doesn't allow the local user to use sudo.
+	SudoNone string = "none"
+)
+
+// sudoServices are the services the local user may manage with the SudoCommands policy.
+var sudoServices = []string{"appneta-cmp.service", "docker.service"}
+
+// ValidSudoPolicy determines if policy is a known sudo policy.
+func ValidSudoPolicy(policy string) bool {
+	return policy == SudoFull || policy == SudoCommands || policy == SudoNone
+}
+
+// sudoersFragment generates the sudoers fragment of a user for a sudo policy.
+func sudoersFragment(user, policy string) (string, error) {
+	header := "# This file is automatically generated by rmon-node-setup. Do not override.\n"
+	switch policy {
+	case SudoFull:
+		return header + fmt.Sprintf("%s ALL=(ALL:ALL) ALL\n", user), nil
+	case SudoCommands:
+		systemctl, err := exec.LookPath("systemctl")
+		if err != nil {
+			return "", err
+		}
+		var cmds []string
+		for _, service := range sudoServices {
+			for _, action := range []string{"start", "stop", "restart", "status"} {
+				cmds = append(cmds, fmt.Sprintf("%s %s %s", systemctl, action, service))
+			}
+		}
+		return header + fmt.Sprintf("%s ALL=(root) NOPASSWD: %s\n", user, strings.Join(cmds, ", ")), nil
+	}
+	return "", fmt.Errorf("invalid sudo policy '%s'", policy)
+}
+
+/*
+AddToSudoers grants sudo to a user per policy, via a fragment in /etc/sudoers.d. The fragment is
+validated with visudo before it's installed, since an invalid fragment would break sudo entirely.
+It is written atomically by WriteFile, so sudo never reads a partial fragment. With SudoNone, any
+existing fragment is removed.
+*/
+func AddToSudoers(user, policy string) {
 	filename := path.Join("/etc/sudoers.d", user)
-	if !FileExists(filename) {
-		file, err := os.Create(filename)
-		Check("Error creating sudoers file %s", err, filename)
-		file.Close()
+
+	if policy == SudoNone {
+		if FileExists(filename) {
+			err := RemoveFile(filename)
+			Check("Error removing sudoers file %s", err, filename)
+			Success("Removed %s from sudoers", user)
+		}
+		return
 	}
-	content, err := ioutil.ReadFile(filename)
-	Check("Error reading sudoers file %s", err, filename)
-	if bytes.Equal(line, content) {
-		result = true
-	} else {
-		file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_TRUNC, os.ModeAppend)
-		Check("Error opening sudoers file %s", err, filename)
-		_, err = file.Write(line)
-		Check("Error writing to sudoers file %s. Tried to write:\n%v", err, filename, content)
-		Success("Added %s to sudoers", user)
-		AddToSudoers(user)
+
+	content, err := sudoersFragment(user, policy)
+	Check("Error generating sudoers file for %s", err, user)
+
+	if existing, err := ioutil.ReadFile(filename); err == nil && string(existing) == content {
+		if info, err := os.Stat(filename); err == nil && info.Mode().Perm() == 0440 {
+			Info("%s is already in sudoers", user)
+			return
+		}
 	}
-	return
+
+	err = WriteFile(filename, []byte(content), FileOptions{Perm: 0440, Validate: func(tmp string) error {
+		out, err := exec.Command("visudo", "-cf", tmp).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("generated sudoers file for %s is invalid: %v\n%s", user, err, AsString(out))
+		}
+		return nil
+	}})
+	Check("Error writing sudoers file %s", err, filename)
+	Success("Added %s to sudoers with the %s policy", user, policy)
 }
 
 // AllGroups gets a list of all groups on the system.
@@ -120,13 +125,13 @@ func AllGroups() (g []string) {
 	return
 }
 
-// UserCommand runs a command with environment variables set for the user.
+// UserCommand runs a command as the local user, with its environment, e.g. for systemctl --user.
 func UserCommand(base string, args ...string) ([]byte, error) {
-	uid := os.Getuid()
-	xdg := fmt.Sprintf("XDG_RUNTIME_DIR=/run/user/%s", strconv.Itoa(uid))
-	cmd := exec.Command(base, args...)
-	cmd.Env = append(os.Environ(), xdg)
-	return cmd.CombinedOutput()
+	id, err := LookupIdentity(g.LocalUser)
+	if err != nil {
+		return nil, err
+	}
+	return id.Command(base, args...).CombinedOutput()
 }
 
 // UserToGroup adds a user to a group that is assumed to exist.
@@ -134,6 +139,9 @@ func UserToGroup(user string, group string) {
 	addUserToGroup := exec.Command("usermod", "-aG", group, user)
 	out, err := addUserToGroup.CombinedOutput()
 	Check("Error adding user %s to group %s:\n%s", err, user, group, AsString(out))
+	OnRollback(fmt.Sprintf("Remove user %s from group %s", user, group), func() error {
+		return commandError(exec.Command("gpasswd", "--delete", user, group).CombinedOutput())
+	})
 	Success("Added user %s to group %s", user, group)
 }
 
@@ -143,6 +151,9 @@ func NewGroup(group string) {
 	addGroup := exec.Command("groupadd", group)
 	out, err := addGroup.CombinedOutput()
 	Check("Error creating group %s:\n%s", err, group, AsString(out))
+	OnRollback("Remove group "+group, func() error {
+		return commandError(exec.Command("groupdel", group).CombinedOutput())
+	})
 	Success("Created group %s", group)
 }
 
@@ -152,3 +163,81 @@ func CurrentUser() *user.User {
 	Check("Error getting current user", err)
 	return user
 }
+
+// usernamePattern matches the usernames accepted by useradd on Debian & RHEL.
+var usernamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// commandError includes the output of a failed command in its error.
+func commandError(out []byte, err error) error {
+	if err != nil {
+		return fmt.Errorf("%v\n%s", err, AsString(out))
+	}
+	return nil
+}
+
+// ValidateUsername checks a local username.
+func ValidateUsername(name string) error {
+	if !usernamePattern.MatchString(name) {
+		return fmt.Errorf("invalid username '%s': must start with a lowercase letter or '_', and may only contain lowercase letters, numbers, '_' & '-', up to 32 characters", name)
+	}
+	if name == "root" {
+		return fmt.Errorf("the local user must not be root")
+	}
+	return nil
+}
+
+/*
+EnsureUser creates the local user if it doesn't exist, with a home directory, a login shell & a
+locked password, so that it can only be logged into with an SSH key or via sudo. Whether it was
+created or already existed, its UID & GID must not be root's, and its home directory must exist &
+be owned by it. Lingering is enabled, so that the user's systemd services start on boot without the
+user logging in.
+*/
+func EnsureUser(name string) *user.User {
+	err := ValidateUsername(name)
+	Check("Error validating local user: ", err)
+
+	u, err := user.Lookup(name)
+	if _, ok := err.(user.UnknownUserError); ok {
+		Info("Creating user %s...", name)
+		home := fmt.Sprintf(g.HomeDir, name)
+		out, err := exec.Command("useradd", "--create-home", "--home-dir", home, "--shell", "/bin/bash", "--user-group", name).CombinedOutput()
+		Check("Error creating user %s:\n%s", err, name, AsString(out))
+		out, err = exec.Command("passwd", "--lock", name).CombinedOutput()
+		Check("Error locking the password of user %s:\n%s", err, name, AsString(out))
+		OnRollback("Remove user "+name+" & its home directory", func() error {
+			exec.Command("loginctl", "disable-linger", name).Run()
+			return commandError(exec.Command("userdel", "--remove", name).CombinedOutput())
+		})
+		Success("Created user %s with home directory %s", name, home)
+		u, err = user.Lookup(name)
+	}
+	Check("Error looking up user %s", err, name)
+
+	uid, err := strconv.Atoi(u.Uid)
+	Check("Error reading UID of user %s", err, name)
+	gid, err := strconv.Atoi(u.Gid)
+	Check("Error reading GID of user %s", err, name)
+	if uid == 0 || gid == 0 {
+		Critical("User %s has UID %s & GID %s, but must not have root's UID or GID", name, u.Uid, u.Gid)
+		Exit(1)
+	}
+
+	info, err := os.Stat(u.HomeDir)
+	Check("Error reading home directory of user %s", err, name)
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(st.Uid) != uid {
+		Critical("Home directory %s of user %s must be a directory owned by %s", u.HomeDir, name, name)
+		Exit(1)
+	}
+	if int(st.Gid) != gid {
+		Warning("Home directory %s of user %s is owned by GID %s, not %s", u.HomeDir, name, strconv.Itoa(int(st.Gid)), u.Gid)
+	}
+
+	out, err := exec.Command("loginctl", "enable-linger", name).CombinedOutput()
+	Check("Error enabling lingering for user %s:\n%s", err, name, AsString(out))
+
+	g.LocalHome = u.HomeDir
+	Success("Using local user %s (UID %s, GID %s, home %s)", name, u.Uid, u.Gid, u.HomeDir)
+	return u
+}
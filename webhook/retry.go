@@ -0,0 +1,33 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	retry "github.com/stellaraf/rmon-node-setup/internal/retry"
+)
+
+/*
+Retriable determines if a failed notification may succeed if it is retried. Transient network errors
+(see retry.Transient) & 5xx, 408 & 429 responses are retriable. Other error responses are not.
+*/
+func Retriable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	return retry.Transient(err)
+}
+
+// retry calls f with the client's retry policy.
+func (c *Client) retry(ctx context.Context, f func() error) error {
+	return retry.Do(ctx, retry.Policy{
+		Retries:    c.Retries,
+		MinBackoff: c.MinBackoff,
+		MaxBackoff: c.MaxBackoff,
+		Retriable:  Retriable,
+		OnRetry:    c.OnRetry,
+	}, f)
+}
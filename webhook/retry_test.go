@@ -0,0 +1,32 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// TestRetriable tests the status codes which are retried. Transient network errors are tested with
+// retry.Transient.
+func TestRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"transient", io.ErrUnexpectedEOF, true},
+		{"invalid URL", errors.New("invalid webhook URL"), false},
+		{"500", &StatusError{StatusCode: 500}, true},
+		{"408", &StatusError{StatusCode: 408}, true},
+		{"429", fmt.Errorf("wrapped: %w", &StatusError{StatusCode: 429}), true},
+		{"400", &StatusError{StatusCode: 400}, false},
+		{"404", &StatusError{StatusCode: 404}, false},
+	}
+	for _, tt := range tests {
+		if got := Retriable(tt.err); got != tt.want {
+			t.Errorf("%s: Retriable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
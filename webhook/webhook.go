@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	retry "github.com/stellaraf/rmon-node-setup/internal/retry"
+)
+
+// Payload formats of a webhook.
+const (
+	// FormatJSON posts the report as-is.
+	FormatJSON string = "json"
+	// FormatSlack posts a Slack message, which Teams' incoming webhooks also accept.
+	FormatSlack string = "slack"
+	// FormatTeams posts a Teams message card, with a title & a color by outcome.
+	FormatTeams string = "teams"
+)
+
+// DefaultTimeout is the default timeout of each attempt to post a notification.
+const DefaultTimeout time.Duration = 30 * time.Second
+
+// DefaultRetries is the default number of times a failed notification is retried.
+const DefaultRetries int = 3
+
+// DefaultMinBackoff is the default wait before the first retry.
+const DefaultMinBackoff time.Duration = 2 * time.Second
+
+// DefaultMaxBackoff is the default maximum wait between retries.
+const DefaultMaxBackoff time.Duration = 30 * time.Second
+
+// DefaultUserAgent is the User-Agent header sent with each notification.
+const DefaultUserAgent string = "rmon-node-setup"
+
+// ValidFormat determines if f is a known payload format.
+func ValidFormat(f string) bool {
+	return f == FormatJSON || f == FormatSlack || f == FormatTeams
+}
+
+// Notification is the outcome of provisioning a node.
+type Notification struct {
+	// Succeeded is true if provisioning succeeded.
+	Succeeded bool
+	// Title is a short description of the outcome, e.g. "Provisioned rpi01.example.com".
+	Title string
+	// Text has the details of the outcome, one per line.
+	Text string
+	// Report is posted as-is with FormatJSON.
+	Report interface{}
+}
+
+// Client posts notifications to a webhook.
+type Client struct {
+	// URL is the URL of the webhook. It may contain a secret, e.g. for Slack, so it isn't included
+	// in errors.
+	URL string
+	// Format is the payload format: FormatJSON, FormatSlack or FormatTeams.
+	Format string
+	// Timeout is the timeout of each attempt.
+	Timeout time.Duration
+	// Retries is the number of times a notification is retried after a timeout, a reset or refused
+	// connection, or a 5xx, 408 or 429 response.
+	Retries int
+	// MinBackoff & MaxBackoff bound the exponential backoff between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnRetry, if set, is called before each retry with the attempt number, the time until the
+	// retry & the error which caused it.
+	OnRetry func(attempt int, wait time.Duration, err error)
+	// UserAgent is the User-Agent header sent with each notification.
+	UserAgent string
+	// HTTP is the underlying HTTP client.
+	HTTP *http.Client
+}
+
+// StatusError is returned if the webhook responds with an error status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("webhook returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("webhook returned %d: %s", e.StatusCode, e.Body)
+}
+
+// NewClient creates a webhook client with the default timeout, retries & user agent.
+func NewClient(webhookURL, format string) *Client {
+	return &Client{
+		URL:        webhookURL,
+		Format:     format,
+		Timeout:    DefaultTimeout,
+		Retries:    DefaultRetries,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		UserAgent:  DefaultUserAgent,
+		HTTP:       &http.Client{},
+	}
+}
+
+// Host gets the host of the webhook, which can be logged unlike the URL.
+func (c *Client) Host() string {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// payload encodes a notification per the client's format.
+func (c *Client) payload(n Notification) ([]byte, error) {
+	switch c.Format {
+	case FormatJSON, "":
+		return json.Marshal(n.Report)
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": "*" + n.Title + "*\n" + n.Text})
+	case FormatTeams:
+		color := "2EB67D"
+		if !n.Succeeded {
+			color = "E01E5A"
+		}
+		return json.Marshal(map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    n.Title,
+			"title":      n.Title,
+			"themeColor": color,
+			// Teams only breaks lines on a blank line or a double space.
+			"text": strings.Replace(n.Text, "\n", "  \n", -1),
+		})
+	}
+	return nil, fmt.Errorf("invalid webhook format '%s'", c.Format)
+}
+
+// send posts a payload to the webhook once.
+func (c *Client) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		// The error includes the URL.
+		return fmt.Errorf("invalid webhook URL")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		// The error includes the URL, so only its cause is returned.
+		if urlErr, ok := err.(*url.Error); ok {
+			return fmt.Errorf("%s %s: %w", urlErr.Op, c.Host(), urlErr.Err)
+		}
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return &StatusError{StatusCode: res.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
+
+// Send posts a notification to the webhook, with retries.
+func (c *Client) Send(ctx context.Context, n Notification) error {
+	body, err := c.payload(n)
+	if err != nil {
+		return err
+	}
+	return c.retry(ctx, func() error {
+		actx, cancel := retry.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+		return c.send(actx, body)
+	})
+}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testClient creates a client of server which retries without waiting.
+func testClient(server *httptest.Server, format string) *Client {
+	c := NewClient(server.URL, format)
+	c.MinBackoff, c.MaxBackoff = time.Millisecond, time.Millisecond
+	return c
+}
+
+var testNotification = Notification{
+	Succeeded: false,
+	Title:     "Provisioning rpi01 failed",
+	Text:      "Node ID: 01\nFailed step: Install Docker",
+	Report:    map[string]interface{}{"status": "failed", "node_id": "01"},
+}
+
+func TestSendPayload(t *testing.T) {
+	tests := []struct {
+		format string
+		want   map[string]interface{}
+	}{
+		{FormatJSON, map[string]interface{}{"status": "failed", "node_id": "01"}},
+		{FormatSlack, map[string]interface{}{"text": "*Provisioning rpi01 failed*\nNode ID: 01\nFailed step: Install Docker"}},
+		{FormatTeams, map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    "Provisioning rpi01 failed",
+			"title":      "Provisioning rpi01 failed",
+			"themeColor": "E01E5A",
+			"text":       "Node ID: 01  \nFailed step: Install Docker",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var got map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("unexpected method %s", r.Method)
+				}
+				if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type is %s", ct)
+				}
+				if ua := r.Header.Get("User-Agent"); ua != DefaultUserAgent {
+					t.Errorf("User-Agent is %s", ua)
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				if err := json.Unmarshal(b, &got); err != nil {
+					t.Errorf("invalid payload %s: %v", b, err)
+				}
+			}))
+			defer server.Close()
+
+			if err := testClient(server, tt.format).Send(context.Background(), testNotification); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got payload %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendTeamsSucceeded(t *testing.T) {
+	c := &Client{Format: FormatTeams}
+	b, err := c.payload(Notification{Succeeded: true, Title: "Provisioned rpi01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["themeColor"] != "2EB67D" {
+		t.Errorf("themeColor is %s", got["themeColor"])
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		status   int
+	}{
+		{"5xx retried", []int{http.StatusBadGateway, http.StatusServiceUnavailable}, 3, 0},
+		{"429 retried", []int{http.StatusTooManyRequests}, 2, 0},
+		{"4xx not retried", []int{http.StatusNotFound}, 1, http.StatusNotFound},
+		{"retries exhausted", []int{500, 500, 500, 500}, 4, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts <= len(tt.statuses) {
+					http.Error(w, "unavailable", tt.statuses[attempts-1])
+				}
+			}))
+			defer server.Close()
+
+			retries := 0
+			c := testClient(server, FormatSlack)
+			c.OnRetry = func(attempt int, wait time.Duration, err error) { retries++ }
+			err := c.Send(context.Background(), testNotification)
+			if attempts != tt.attempts || retries != tt.attempts-1 {
+				t.Errorf("made %d attempt(s) & %d retries, want %d attempt(s)", attempts, retries, tt.attempts)
+			}
+			if tt.status == 0 {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || statusErr.Body != "unavailable" {
+				t.Errorf("got error %v, want a %d StatusError", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestSendInvalidFormat(t *testing.T) {
+	c := NewClient("https://example.invalid/hook", "xml")
+	if err := c.Send(context.Background(), testNotification); err == nil {
+		t.Error("an invalid format was sent")
+	}
+}